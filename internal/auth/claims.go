@@ -80,5 +80,75 @@ func (c *WorkerClaims) ParseClaims(claims jwt.MapClaims) error {
 	}
 	c.Addr = addr.(string)
 
+	if jti, ok := claims["jti"]; ok {
+		c.Id, _ = jti.(string)
+	}
+
+	return nil
+}
+
+// jobAudience is audience of job access tokens, so they are never
+// accepted as user or worker tokens and vice versa.
+const jobAudience = "job"
+
+// JobClaims represent the claims parsed from JWT access token issued
+// to the job when it is dispatched to the worker.
+type JobClaims struct {
+	JobID  uint `json:"job"`
+	RepoID uint `json:"repo"`
+	jwt.StandardClaims
+}
+
+// ParseClaims parses JWT claims into JobClaims.
+func (c *JobClaims) ParseClaims(claims jwt.MapClaims) error {
+	if aud, _ := claims["aud"].(string); aud != jobAudience {
+		return fmt.Errorf("could not parse aud claim")
+	}
+
+	job, ok := claims["job"].(float64)
+	if !ok {
+		return fmt.Errorf("could not parse job claim")
+	}
+	c.JobID = uint(job)
+
+	repo, ok := claims["repo"].(float64)
+	if !ok {
+		return fmt.Errorf("could not parse repo claim")
+	}
+	c.RepoID = uint(repo)
+
+	return nil
+}
+
+// cloneAudience is audience of clone access tokens, which are accepted
+// only by git proxy.
+const cloneAudience = "clone"
+
+// CloneClaims represent the claims parsed from JWT access token issued
+// to the job for cloning its repository.
+type CloneClaims struct {
+	JobID  uint `json:"job"`
+	RepoID uint `json:"repo"`
+	jwt.StandardClaims
+}
+
+// ParseClaims parses JWT claims into CloneClaims.
+func (c *CloneClaims) ParseClaims(claims jwt.MapClaims) error {
+	if aud, _ := claims["aud"].(string); aud != cloneAudience {
+		return fmt.Errorf("could not parse aud claim")
+	}
+
+	job, ok := claims["job"].(float64)
+	if !ok {
+		return fmt.Errorf("could not parse job claim")
+	}
+	c.JobID = uint(job)
+
+	repo, ok := claims["repo"].(float64)
+	if !ok {
+		return fmt.Errorf("could not parse repo claim")
+	}
+	c.RepoID = uint(repo)
+
 	return nil
 }
@@ -50,6 +50,28 @@ func (a *JWTAuth) CreateWorkerJWT(c WorkerClaims) (string, error) {
 	return tokenString, err
 }
 
+// CreateJobJWT returns an access token for provided job claims which
+// expires after ttl.
+func (a *JWTAuth) CreateJobJWT(c JobClaims, ttl time.Duration) (string, error) {
+	c.IssuedAt = time.Now().Unix()
+	c.ExpiresAt = time.Now().Add(ttl).Unix()
+	c.Issuer = "Abstruse CI"
+	c.Audience = jobAudience
+	_, tokenString, err := a.encode(c)
+	return tokenString, err
+}
+
+// CreateCloneJWT returns an access token for provided clone claims which
+// expires after ttl.
+func (a *JWTAuth) CreateCloneJWT(c CloneClaims, ttl time.Duration) (string, error) {
+	c.IssuedAt = time.Now().Unix()
+	c.ExpiresAt = time.Now().Add(ttl).Unix()
+	c.Issuer = "Abstruse CI"
+	c.Audience = cloneAudience
+	_, tokenString, err := a.encode(c)
+	return tokenString, err
+}
+
 // UserClaimsFromJWT returns user data included in token.
 func UserClaimsFromJWT(tokenString string) (UserClaims, error) {
 	var c UserClaims
@@ -100,6 +122,31 @@ func WorkerClaimsFromJWT(tokenString string) (WorkerClaims, error) {
 	return c, fmt.Errorf("invalid token")
 }
 
+// CloneClaimsFromJWT returns clone data included in token.
+func CloneClaimsFromJWT(tokenString string) (CloneClaims, error) {
+	var c CloneClaims
+	if tokenString == "" {
+		return c, fmt.Errorf("invalid token")
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return c, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return JWTSecret, nil
+	})
+	if err != nil {
+		return c, fmt.Errorf("invalid token")
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		err := c.ParseClaims(claims)
+		return c, err
+	}
+
+	return c, fmt.Errorf("invalid token")
+}
+
 // Verifier http middleware handler will verify a JWT string from a http request.
 func (a *JWTAuth) Verifier() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -6,17 +6,19 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-// GenerateWorkerJWT generates workers json web token.
-func GenerateWorkerJWT(id string) (string, error) {
+// GenerateWorkerJWT generates workers json web token signed
+// with provided secret.
+func GenerateWorkerJWT(id string, secret []byte) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"identifier": id,
 	})
 
-	return token.SignedString(JWTSecret)
+	return token.SignedString(secret)
 }
 
-// GetWorkerIdentifierByJWT return workers id by token string.
-func GetWorkerIdentifierByJWT(token string) (string, error) {
+// GetWorkerIdentifierByJWT return workers id by token string
+// signed with provided secret.
+func GetWorkerIdentifierByJWT(token string, secret []byte) (string, error) {
 	var id string
 
 	if token == "" {
@@ -28,7 +30,7 @@ func GetWorkerIdentifierByJWT(token string) (string, error) {
 			return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
 		}
 
-		return JWTSecret, nil
+		return secret, nil
 	})
 	if err != nil {
 		return id, err
@@ -49,11 +49,20 @@ func ReadFile(filePath string) (string, error) {
 
 // TempDir returns path to temporary directory
 func TempDir() (string, error) {
-	var tmp string
+	return TempDirPrefix("abstruse")
+}
+
+// TempDirPrefix returns path to temporary directory which
+// name begins with prefix.
+func TempDirPrefix(prefix string) (string, error) {
+	return ioutil.TempDir(TempRoot(), prefix)
+}
+
+// TempRoot returns path to directory where temporary
+// directories are created.
+func TempRoot() string {
 	if runtime.GOOS != "darwin" {
-		tmp = os.TempDir()
-	} else {
-		tmp = "/tmp"
+		return os.TempDir()
 	}
-	return ioutil.TempDir(tmp, "abstruse")
+	return "/tmp"
 }
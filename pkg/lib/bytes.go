@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseMemory parses memory size in Docker notation, number of bytes with
+// optional b, k, m or g unit suffix (binary units), e.g. 512m or 2g.
+func ParseMemory(str string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(str))
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	switch s[len(s)-1] {
+	case 'b':
+		s = s[:len(s)-1]
+	case 'k':
+		unit, s = 1<<10, s[:len(s)-1]
+	case 'm':
+		unit, s = 1<<20, s[:len(s)-1]
+	case 'g':
+		unit, s = 1<<30, s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size: %s", str)
+	}
+	return int64(n * float64(unit)), nil
+}
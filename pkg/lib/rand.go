@@ -11,3 +11,10 @@ func RandomString() string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
+
+// RandomHexString returns hex encoded string of n random bytes.
+func RandomHexString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,13 @@
+package stats
+
+import "github.com/shirou/gopsutil/disk"
+
+// GetDiskUsage returns used disk space in percent for the
+// filesystem containing path.
+func GetDiskUsage(path string) (int, error) {
+	stat, err := disk.Usage(path)
+	if err != nil {
+		return 0, err
+	}
+	return int(stat.UsedPercent), nil
+}
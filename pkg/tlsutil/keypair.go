@@ -0,0 +1,141 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Keypair holds TLS certificate that can be replaced at runtime.
+// It is meant to be used with tls.Config callbacks so that rotated
+// certificates are picked up by new connections without restart.
+type Keypair struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewKeypair returns new Keypair instance.
+func NewKeypair(cert tls.Certificate) (*Keypair, error) {
+	kp := &Keypair{}
+	if err := kp.Set(cert); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// LoadKeypair loads PEM encoded certificate and key from disk. Pair
+// left pending by interrupted SaveKeypair is put in place first.
+func LoadKeypair(certPath, keyPath string) (*Keypair, error) {
+	if err := commitKeypair(certPath, keyPath); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeypair(cert)
+}
+
+// SaveKeypair writes PEM encoded certificate and key to disk. Both are
+// written next to current files first and then swapped together, so
+// certificate and key on disk always match.
+func SaveKeypair(certPath, keyPath string, certPEM, keyPEM []byte) error {
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(pendingPath(keyPath), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(pendingPath(certPath), certPEM, 0644); err != nil {
+		os.Remove(pendingPath(keyPath))
+		return err
+	}
+	return commitKeypair(certPath, keyPath)
+}
+
+// commitKeypair moves pending certificate and key in place of current
+// ones. Pending files which do not make a valid pair together with the
+// rest of the pair are left over from incomplete save and are removed.
+func commitKeypair(certPath, keyPath string) error {
+	certPending, keyPending := pendingPath(certPath), pendingPath(keyPath)
+	certFile, keyFile := certPath, keyPath
+	if _, err := os.Stat(certPending); err == nil {
+		certFile = certPending
+	}
+	if _, err := os.Stat(keyPending); err == nil {
+		keyFile = keyPending
+	}
+	if certFile == certPath && keyFile == keyPath {
+		return nil
+	}
+
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		os.Remove(certPending)
+		os.Remove(keyPending)
+		return nil
+	}
+	if keyFile == keyPending {
+		if err := os.Rename(keyPending, keyPath); err != nil {
+			return err
+		}
+	}
+	if certFile == certPending {
+		return os.Rename(certPending, certPath)
+	}
+	return nil
+}
+
+func pendingPath(filePath string) string {
+	return filePath + ".new"
+}
+
+// Set replaces current certificate.
+func (kp *Keypair) Set(cert tls.Certificate) error {
+	if cert.Leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return fmt.Errorf("empty certificate")
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.cert = &cert
+	return nil
+}
+
+// Leaf returns parsed current certificate.
+func (kp *Keypair) Leaf() *x509.Certificate {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.cert.Leaf
+}
+
+// NeedsRenewal returns true when less than third of certificate
+// lifetime is remaining.
+func (kp *Keypair) NeedsRenewal() bool {
+	leaf := kp.Leaf()
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return time.Until(leaf.NotAfter) < lifetime/3
+}
+
+// GetCertificate returns current certificate, used in tls.Config.
+func (kp *Keypair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.cert, nil
+}
+
+// GetClientCertificate returns current certificate, used in tls.Config.
+func (kp *Keypair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.cert, nil
+}
@@ -55,6 +55,13 @@ func New(
 	workers core.WorkerRegistry,
 	scheduler core.Scheduler,
 	stats core.StatsService,
+	pki core.PKIService,
+	enrollmentTokens core.EnrollmentTokenStore,
+	workerIdentities core.WorkerIdentityStore,
+	autoscaler core.Autoscaler,
+	blobs core.BlobStore,
+	caches core.CacheStore,
+	cacheService core.CacheService,
 ) *Router {
 	return &Router{
 		Config:       config,
@@ -72,6 +79,14 @@ func New(
 		Workers:      workers,
 		Scheduler:    scheduler,
 		Stats:        stats,
+		PKI:          pki,
+
+		EnrollmentTokens: enrollmentTokens,
+		WorkerIdentities: workerIdentities,
+		Autoscaler:       autoscaler,
+		Blobs:            blobs,
+		Caches:           caches,
+		CacheService:     cacheService,
 	}
 }
 
@@ -92,6 +107,14 @@ type Router struct {
 	Workers      core.WorkerRegistry
 	Scheduler    core.Scheduler
 	Stats        core.StatsService
+	PKI          core.PKIService
+
+	EnrollmentTokens core.EnrollmentTokenStore
+	WorkerIdentities core.WorkerIdentityStore
+	Autoscaler       core.Autoscaler
+	Blobs            core.BlobStore
+	Caches           core.CacheStore
+	CacheService     core.CacheService
 }
 
 // Handler returns the http.Handler.
@@ -195,6 +218,9 @@ func (r Router) providersRouter() *chi.Mux {
 	router.Put("/", provider.HandleUpdate(r.Providers, r.Users))
 	router.Get("/{id}", provider.HandleFind(r.Providers, r.Users))
 	router.Delete("/{id}", provider.HandleDelete(r.Providers, r.Users))
+	router.Get("/{id}/known-hosts", provider.HandleKnownHosts(r.Providers, r.Users))
+	router.Put("/{id}/known-hosts", provider.HandleUpdateKnownHosts(r.Providers, r.Users))
+	router.Post("/{id}/known-hosts/scan", provider.HandleScanKnownHosts(r.Providers, r.Users))
 	router.Put("/sync", provider.HandleSync(r.Providers))
 
 	return router
@@ -219,6 +245,9 @@ func (r Router) reposRouter() *chi.Mux {
 	router.Delete("/{id}/mounts/{mountid}", repo.HandleDeleteMount(r.Mounts, r.Repos))
 	router.Put("/{id}/ssh-private-key", repo.HandleUpdateSSHPrivateKey(r.Repos))
 	router.Put("/{id}/misc", repo.HandleUpdateMisc(r.Repos))
+	router.Get("/{id}/caches", repo.HandleListCaches(r.Caches, r.Repos))
+	router.Delete("/{id}/caches", repo.HandleDeleteCaches(r.Caches, r.CacheService, r.Repos))
+	router.Delete("/{id}/caches/{cacheid}", repo.HandleDeleteCache(r.Caches, r.CacheService, r.Repos))
 
 	return router
 }
@@ -242,11 +271,36 @@ func (r Router) workersRouter() *chi.Mux {
 	router := chi.NewRouter()
 
 	router.Get("/", worker.HandleList(r.Workers))
+	router.Post("/enroll", worker.HandleEnroll(r.EnrollmentTokens, r.WorkerIdentities))
 	router.Group(func(router chi.Router) {
-		router.Use(auth.JWT.Verifier(), middlewares.WorkerAuthenticator)
-		router.Post("/auth", worker.HandleAuth(r.Workers, r.Config, r.WS.App))
-		router.Post("/cache", worker.HandleUploadCache(r.Config))
-		router.Get("/cache", worker.HandleDownloadCache(r.Config))
+		router.Use(auth.JWT.Verifier(), middlewares.Authenticator)
+		router.Get("/tokens", worker.HandleListTokens(r.EnrollmentTokens, r.Users))
+		router.Post("/tokens", worker.HandleCreateToken(r.EnrollmentTokens, r.Users))
+		router.Delete("/tokens/{id}", worker.HandleDeleteToken(r.EnrollmentTokens, r.Users))
+		router.Get("/identities", worker.HandleListIdentities(r.WorkerIdentities, r.Users))
+		router.Put("/identities/{id}/approve", worker.HandleApprove(r.WorkerIdentities, r.Users))
+		router.Put("/identities/{id}/revoke", worker.HandleRevoke(r.WorkerIdentities, r.Workers, r.Users))
+		router.Delete("/identities/{id}", worker.HandleDeleteIdentity(r.WorkerIdentities, r.Workers, r.Users))
+		router.Put("/{id}/capacity", worker.HandleCapacity(r.Scheduler, r.Users))
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(auth.JWT.Verifier(), middlewares.WorkerAuthenticator(r.WorkerIdentities))
+		router.Post("/auth", worker.HandleAuth(r.Workers, r.WorkerIdentities, r.PKI, r.WS.App))
+		router.Post("/certificate", worker.HandleCertificate(r.PKI))
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(auth.JWT.Verifier(), middlewares.JobAuthenticator(r.Jobs))
+		router.Post("/cache/uploads", worker.HandleCreateCacheUpload(r.Blobs, r.Config))
+		router.Put("/cache/uploads/{id}", worker.HandleUploadCacheChunk(r.Blobs, r.Config))
+		router.Get("/cache/uploads/{id}/url", worker.HandleCacheChunkURL(r.Blobs, r.Config))
+		router.Post("/cache/uploads/{id}/complete", worker.HandleCompleteCacheUpload(r.Blobs, r.CacheService, r.Config))
+		router.Get("/cache", worker.HandleDownloadCache(r.Blobs, r.CacheService, r.Config))
+		router.Head("/cache", worker.HandleCacheExists(r.Blobs, r.Config))
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(middlewares.CloneAuthenticator(r.Jobs))
+		router.Get("/git/*", worker.HandleGitProxy(r.Jobs, r.Logger))
+		router.Post("/git/*", worker.HandleGitProxy(r.Jobs, r.Logger))
 	})
 
 	return router
@@ -267,6 +321,8 @@ func (r Router) statsRouter() *chi.Mux {
 	router.Get("/jobs", stats.HandleJobs(r.Jobs))
 	router.Put("/scheduler/resume", stats.HandleResume(r.Users, r.Scheduler))
 	router.Put("/scheduler/pause", stats.HandlePause(r.Users, r.Scheduler))
+	router.Get("/autoscaler", stats.HandleAutoscaler(r.Autoscaler))
+	router.Get("/caches", stats.HandleCaches(r.Users, r.CacheService))
 
 	return router
 }
@@ -44,30 +44,57 @@ func ClaimsFromCtx(ctx context.Context) auth.UserClaims {
 	return ctx.Value(ctxClaims).(auth.UserClaims)
 }
 
-// WorkerAuthenticator middleware.
-func WorkerAuthenticator(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, claims, err := auth.FromContext(r.Context())
+// WorkerAuthenticator middleware. Worker must be enrolled and approved,
+// unknown workers are registered in pending state awaiting approval.
+func WorkerAuthenticator(identities core.WorkerIdentityStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, claims, err := auth.FromContext(r.Context())
 
-		if err != nil {
-			render.UnathorizedError(w, err.Error())
-			return
-		}
+			if err != nil {
+				render.UnathorizedError(w, err.Error())
+				return
+			}
 
-		if !token.Valid {
-			render.UnathorizedError(w, "token expired")
-			return
-		}
+			if !token.Valid {
+				render.UnathorizedError(w, "token expired")
+				return
+			}
 
-		var c auth.WorkerClaims
-		if err := c.ParseClaims(claims); err != nil {
-			render.UnathorizedError(w, "invalid access token")
-			return
-		}
+			var c auth.WorkerClaims
+			if err := c.ParseClaims(claims); err != nil {
+				render.UnathorizedError(w, "invalid access token")
+				return
+			}
 
-		ctx := context.WithValue(r.Context(), ctxClaims, c)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			identity, err := identities.Find(c.ID)
+			if err != nil {
+				identity = &core.WorkerIdentity{WorkerID: c.ID, Addr: c.Addr, Status: core.WorkerStatusPending}
+				if err := identities.Create(identity); err != nil {
+					render.InternalServerError(w, err.Error())
+					return
+				}
+			}
+
+			if identity.TokenID != "" && identity.TokenID != c.Id {
+				render.UnathorizedError(w, "invalid access token")
+				return
+			}
+
+			switch identity.Status {
+			case core.WorkerStatusApproved:
+			case core.WorkerStatusRevoked:
+				render.UnathorizedError(w, "worker identity revoked")
+				return
+			default:
+				render.ForbiddenError(w, "worker pending approval")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxClaims, c)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // WorkerClaimsFromCtx returns worker claims from context.
@@ -75,6 +102,83 @@ func WorkerClaimsFromCtx(ctx context.Context) auth.WorkerClaims {
 	return ctx.Value(ctxClaims).(auth.WorkerClaims)
 }
 
+// JobAuthenticator middleware. Job token is accepted only while the job
+// is running, so it can not be used after the job has finished.
+func JobAuthenticator(jobs core.JobStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, claims, err := auth.FromContext(r.Context())
+
+			if err != nil {
+				render.UnathorizedError(w, err.Error())
+				return
+			}
+
+			if !token.Valid {
+				render.UnathorizedError(w, "token expired")
+				return
+			}
+
+			var c auth.JobClaims
+			if err := c.ParseClaims(claims); err != nil {
+				render.UnathorizedError(w, "invalid access token")
+				return
+			}
+
+			job, err := jobs.Find(c.JobID)
+			if err != nil || job.Build == nil || job.Build.RepositoryID != c.RepoID || job.Status != "running" {
+				render.UnathorizedError(w, "job is not running")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxClaims, c)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// JobClaimsFromCtx returns job claims from context.
+func JobClaimsFromCtx(ctx context.Context) auth.JobClaims {
+	return ctx.Value(ctxClaims).(auth.JobClaims)
+}
+
+// CloneAuthenticator middleware. Clone token is expected as password of
+// HTTP basic authentication as sent by git, it is accepted only while
+// the job is running.
+func CloneAuthenticator(jobs core.JobStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, password, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="abstruse"`)
+				render.UnathorizedError(w, "token not found")
+				return
+			}
+
+			c, err := auth.CloneClaimsFromJWT(password)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="abstruse"`)
+				render.UnathorizedError(w, "invalid access token")
+				return
+			}
+
+			job, err := jobs.Find(c.JobID)
+			if err != nil || job.Build == nil || job.Build.RepositoryID != c.RepoID || job.Status != "running" {
+				render.UnathorizedError(w, "job is not running")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxClaims, c)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CloneClaimsFromCtx returns clone claims from context.
+func CloneClaimsFromCtx(ctx context.Context) auth.CloneClaims {
+	return ctx.Value(ctxClaims).(auth.CloneClaims)
+}
+
 // SetupAuthenticator middleware.
 func SetupAuthenticator(users core.UserStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -0,0 +1,228 @@
+package provider
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// scanTimeout is timeout of connection to SSH host when scanning its keys.
+const scanTimeout = 10 * time.Second
+
+// scanAlgorithms are host key algorithms of scanned host keys.
+var scanAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// HandleKnownHosts writes JSON encoded known SSH host keys of provider
+// to the http response body.
+func HandleKnownHosts(providers core.ProviderStore, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		user, err := users.Find(claims.ID)
+		if err != nil {
+			render.UnathorizedError(w, err.Error())
+			return
+		}
+
+		provider, err := providers.Find(uint(id))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		if provider.UserID != claims.ID && user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		hosts, err := core.ParseKnownHosts(provider.KnownHosts)
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, hosts)
+	}
+}
+
+// HandleScanKnownHosts returns an http.HandlerFunc that writes JSON
+// encoded host keys presented by SSH host to the http response body.
+// Keys are not stored, admin confirms them with HandleUpdateKnownHosts.
+func HandleScanKnownHosts(providers core.ProviderStore, users core.UserStore) http.HandlerFunc {
+	type form struct {
+		Host string `json:"host" valid:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+		var f form
+		defer r.Body.Close()
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if valid, err := govalidator.ValidateStruct(f); err != nil || !valid {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if _, err := providers.Find(uint(id)); err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		hosts, err := scanHostKeys(f.Host)
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, hosts)
+	}
+}
+
+// HandleUpdateKnownHosts returns an http.HandlerFunc that writes JSON
+// encoded result about updating known SSH host keys of provider to the
+// http response body.
+func HandleUpdateKnownHosts(providers core.ProviderStore, users core.UserStore) http.HandlerFunc {
+	type form struct {
+		KnownHosts string `json:"knownHosts"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+		var f form
+		defer r.Body.Close()
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		hosts, err := core.ParseKnownHosts(f.KnownHosts)
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		provider, err := providers.Find(uint(id))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		var lines string
+		for _, host := range hosts {
+			lines += host.Line + "\n"
+		}
+		provider.KnownHosts = lines
+		if err := providers.UpdateKnownHosts(provider); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, hosts)
+	}
+}
+
+// scanHostKeys connects to SSH host and returns its host keys, one
+// connection is made for each host key algorithm.
+func scanHostKeys(host string) ([]*core.KnownHost, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "22")
+	}
+
+	var hosts []*core.KnownHost
+	var err error
+	for _, algorithm := range scanAlgorithms {
+		var key ssh.PublicKey
+		if key, err = scanHostKey(addr, algorithm); err != nil {
+			continue
+		}
+		line := knownhosts.Line([]string{addr}, key)
+		hosts = append(hosts, &core.KnownHost{
+			Hosts:       []string{knownhosts.Normalize(addr)},
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Line:        line,
+		})
+	}
+	if len(hosts) == 0 {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// scanHostKey connects to SSH host at addr and returns its host key of
+// algorithm, handshake is aborted once the key is received.
+func scanHostKey(addr, algorithm string) (ssh.PublicKey, error) {
+	conn, err := net.DialTimeout("tcp", addr, scanTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(scanTimeout))
+
+	var key ssh.PublicKey
+	_, _, _, err = ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:              "git",
+		HostKeyAlgorithms: []string{algorithm},
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyScanned
+		},
+	})
+	if key == nil {
+		return nil, err
+	}
+	return key, nil
+}
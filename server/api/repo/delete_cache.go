@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleDeleteCache returns http.HandlerFunc that deletes cache of the
+// repository and writes JSON encoded result to the http response body.
+func HandleDeleteCache(caches core.CacheStore, service core.CacheService, repos core.RepositoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		if perm := repos.GetPermissions(uint(id), claims.ID); !perm.Write {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		cacheid, err := strconv.Atoi(chi.URLParam(r, "cacheid"))
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		cache, err := caches.Find(uint(cacheid))
+		if err != nil || cache.RepositoryID != uint(id) {
+			render.NotFoundError(w, "cache not found")
+			return
+		}
+
+		if err := service.Delete(r.Context(), cache); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, render.Empty{})
+	}
+}
+
+// HandleDeleteCaches returns http.HandlerFunc that deletes all caches of
+// the repository and writes JSON encoded result to the http response
+// body.
+func HandleDeleteCaches(caches core.CacheStore, service core.CacheService, repos core.RepositoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		if perm := repos.GetPermissions(uint(id), claims.ID); !perm.Write {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		list, err := caches.List(uint(id))
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		for _, cache := range list {
+			if err := service.Delete(r.Context(), cache); err != nil {
+				render.InternalServerError(w, err.Error())
+				return
+			}
+		}
+
+		render.JSON(w, http.StatusOK, render.Empty{})
+	}
+}
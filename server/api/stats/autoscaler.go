@@ -0,0 +1,16 @@
+package stats
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// HandleAutoscaler returns an http.HandlerFunc that writes JSON encoded
+// autoscaler status to the http response body.
+func HandleAutoscaler(autoscaler core.Autoscaler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, http.StatusOK, autoscaler.Status())
+	}
+}
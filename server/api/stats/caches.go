@@ -0,0 +1,30 @@
+package stats
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// HandleCaches returns an http.HandlerFunc which writes JSON encoded
+// cache storage usage to the http response body.
+func HandleCaches(users core.UserStore, caches core.CacheService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		usage, err := caches.Usage()
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, usage)
+	}
+}
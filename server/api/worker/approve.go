@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleApprove returns an http.HandlerFunc that writes JSON encoded
+// result about approving pending worker to the http response body.
+func HandleApprove(identities core.WorkerIdentityStore, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		identity, err := identities.Find(chi.URLParam(r, "id"))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		if identity.Status == core.WorkerStatusRevoked {
+			render.BadRequestError(w, "worker identity revoked")
+			return
+		}
+
+		identity.Status = core.WorkerStatusApproved
+		if err := identities.Update(identity); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, identity)
+	}
+}
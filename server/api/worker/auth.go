@@ -5,17 +5,17 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/bleenco/abstruse/server/api/middlewares"
 	"github.com/bleenco/abstruse/server/api/render"
-	"github.com/bleenco/abstruse/server/config"
 	"github.com/bleenco/abstruse/server/core"
 	"github.com/bleenco/abstruse/server/ws"
 )
 
 // HandleAuth returns an http.HandlerFunc that writes JSON encoded
 // result of worker node authorization to http response body.
-func HandleAuth(workers core.WorkerRegistry, config *config.Config, ws *ws.App) http.HandlerFunc {
+func HandleAuth(workers core.WorkerRegistry, identities core.WorkerIdentityStore, pki core.PKIService, ws *ws.App) http.HandlerFunc {
 	type resp struct {
 		Auth string `json:"auth"`
 	}
@@ -33,7 +33,19 @@ func HandleAuth(workers core.WorkerRegistry, config *config.Config, ws *ws.App)
 		}
 		addr := net.JoinHostPort(host, port)
 
-		worker, err := core.NewWorker(claims.ID, addr, config, workers, ws)
+		identity, err := identities.Find(claims.ID)
+		if err != nil {
+			render.UnathorizedError(w, err.Error())
+			return
+		}
+		now := time.Now()
+		identity.Addr, identity.LastSeen = addr, &now
+		if err := identities.Update(identity); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		worker, err := core.NewWorker(claims.ID, addr, identity.Secret, pki, workers, ws)
 		if err != nil {
 			render.UnathorizedError(w, err.Error())
 			return
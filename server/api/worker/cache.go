@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bleenco/abstruse/internal/auth"
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// uploadPrefix is key prefix of unfinished cache uploads.
+const uploadPrefix = "uploads/cache/"
+
+// repoCacheKey returns key in cache namespace of the repository of the
+// job, so jobs can access only caches of their own repository.
+func repoCacheKey(claims auth.JobClaims, key string) string {
+	return fmt.Sprintf("%d/%s", claims.RepoID, key)
+}
+
+// findCache returns key and blob of the cache archive with key or the
+// most recent archive with key starting with one of restore keys, which
+// are tried in order.
+func findCache(ctx context.Context, blobs core.BlobStore, key string, restoreKeys []string) (string, *core.Blob, error) {
+	blobKey, err := core.CacheBlobKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	blob, err := blobs.Stat(ctx, blobKey)
+	if err == nil {
+		return key, blob, nil
+	}
+	if err != core.ErrBlobNotFound {
+		return "", nil, err
+	}
+	if len(restoreKeys) == 0 {
+		return "", nil, core.ErrBlobNotFound
+	}
+
+	list, err := blobs.List(ctx, core.CacheBlobPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, prefix := range restoreKeys {
+		if prefix == "" {
+			continue
+		}
+		var match string
+		var latest *core.Blob
+		for _, blob := range list {
+			name, ok := core.CacheKeyFromBlob(blob.Key)
+			if !ok || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if latest == nil || blob.ModTime.After(latest.ModTime) {
+				match, latest = name, blob
+			}
+		}
+		if latest != nil {
+			return match, latest, nil
+		}
+	}
+	return "", nil, core.ErrBlobNotFound
+}
+
+// presignExpiration is validity of presigned cache URLs.
+const presignExpiration = 15 * time.Minute
+
+// presigner returns blob store as core.BlobPresigner when presigned URLs
+// are enabled and supported by the storage driver.
+func presigner(blobs core.BlobStore, config *config.Config) (core.BlobPresigner, bool) {
+	if config.Storage == nil || !config.Storage.Presign {
+		return nil, false
+	}
+	p, ok := blobs.(core.BlobPresigner)
+	return p, ok
+}
+
+// errStaleChecksum is returned when checksum is older than the cache
+// archive, which is being replaced.
+var errStaleChecksum = errors.New("cache is being replaced")
+
+// readChecksum returns SHA-256 checksum of the cache archive blob.
+// Checksum is written after the archive, so checksum older than the
+// archive belongs to the replaced archive.
+func readChecksum(ctx context.Context, blobs core.BlobStore, blob *core.Blob) (string, error) {
+	stat, err := blobs.Stat(ctx, blob.Key+core.CacheChecksumExt)
+	if err != nil {
+		return "", err
+	}
+	if stat.ModTime.Before(blob.ModTime) {
+		return "", errStaleChecksum
+	}
+	r, err := blobs.Get(ctx, blob.Key+core.CacheChecksumExt, 0)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	checksum, err := ioutil.ReadAll(io.LimitReader(r, 64))
+	return string(checksum), err
+}
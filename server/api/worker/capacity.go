@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleCapacity returns an http.HandlerFunc that writes JSON encoded
+// result about changing worker max parallel jobs to the http response body.
+func HandleCapacity(scheduler core.Scheduler, users core.UserStore) http.HandlerFunc {
+	type form struct {
+		Max int `json:"max" valid:"range(1|1000),required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+		var f form
+		defer r.Body.Close()
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if valid, err := govalidator.ValidateStruct(f); err != nil || !valid {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if err := scheduler.SetCapacity(chi.URLParam(r, "id"), f.Max); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, render.Empty{})
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// HandleCertificate returns an http.HandlerFunc that writes JSON encoded
+// worker certificate signed by server CA to the http response body.
+func HandleCertificate(pki core.PKIService) http.HandlerFunc {
+	type form struct {
+		CSR string `json:"csr" valid:"required"`
+	}
+
+	type resp struct {
+		Cert string `json:"cert"`
+		CA   string `json:"ca"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.WorkerClaimsFromCtx(r.Context())
+		var f form
+		defer r.Body.Close()
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if valid, err := govalidator.ValidateStruct(f); err != nil || !valid {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		cert, err := pki.SignWorkerCert(claims.ID, []byte(f.CSR))
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, resp{Cert: string(cert), CA: string(pki.CACert())})
+	}
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// HandleCreateToken returns an http.HandlerFunc that writes JSON encoded
+// newly created enrollment token to the http response body. Plain token
+// value is included in the response only once.
+func HandleCreateToken(tokens core.EnrollmentTokenStore, users core.UserStore) http.HandlerFunc {
+	type form struct {
+		Description     string     `json:"description"`
+		Reusable        bool       `json:"reusable"`
+		RequireApproval bool       `json:"requireApproval"`
+		ExpiresAt       *time.Time `json:"expiresAt"`
+	}
+
+	type resp struct {
+		*core.EnrollmentToken
+		Token string `json:"token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+		var f form
+		defer r.Body.Close()
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		plain := lib.RandomHexString(24)
+		token := &core.EnrollmentToken{
+			Description:     f.Description,
+			Hash:            core.HashEnrollmentToken(plain),
+			Prefix:          plain[:8],
+			Reusable:        f.Reusable,
+			RequireApproval: f.RequireApproval,
+			ExpiresAt:       f.ExpiresAt,
+			UserID:          claims.ID,
+		}
+
+		if err := tokens.Create(token); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, resp{token, plain})
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleDeleteIdentity returns an http.HandlerFunc that writes JSON encoded
+// result about deleting worker identity to the http response body.
+// Deleted worker can enroll again using valid enrollment token.
+func HandleDeleteIdentity(identities core.WorkerIdentityStore, workers core.WorkerRegistry, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		identity, err := identities.Find(chi.URLParam(r, "id"))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		if err := identities.Delete(identity); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		disconnect(workers, identity.WorkerID)
+
+		render.JSON(w, http.StatusOK, render.Empty{})
+	}
+}
+
+// disconnect closes connection to the worker node and removes
+// it from the registry.
+func disconnect(workers core.WorkerRegistry, id string) {
+	list, err := workers.List()
+	if err != nil {
+		return
+	}
+	for _, worker := range list {
+		if worker.ID == id {
+			worker.Conn.Close()
+			workers.Delete(id)
+		}
+	}
+}
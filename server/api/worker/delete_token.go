@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleDeleteToken returns an http.HandlerFunc that writes JSON encoded
+// result about deleting enrollment token to the http response body.
+func HandleDeleteToken(tokens core.EnrollmentTokenStore, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		token, err := tokens.Find(uint(id))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		if err := tokens.Delete(token); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, render.Empty{})
+	}
+}
@@ -4,61 +4,152 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
+	"strings"
 
-	"github.com/bleenco/abstruse/pkg/fs"
+	"github.com/bleenco/abstruse/server/api/middlewares"
 	"github.com/bleenco/abstruse/server/api/render"
 	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
 )
 
-// HandleDownloadCache returns http.handlerFunc that writes
-// cache file to the http response body (if found).
-func HandleDownloadCache(config *config.Config) http.HandlerFunc {
+// HandleDownloadCache returns http.handlerFunc that writes cache archive
+// with key or the most recent cache archive matching one of restore keys
+// in the namespace of the repository of the job to the http response
+// body (if found). Key of the cache is written to
+// X-Cache-Key header and its SHA-256 checksum to X-Checksum header.
+// Interrupted downloads can be resumed with range requests from offset
+// to the end. When presigned URLs are enabled, it redirects to the
+// archive in the storage instead. Restored cache is marked as used, so
+// it is evicted later.
+func HandleDownloadCache(blobs core.BlobStore, caches core.CacheService, config *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("file") == "" {
-			render.BadRequestError(w, "file not specified")
+		claims := middlewares.JobClaimsFromCtx(r.Context())
+		if r.URL.Query().Get("key") == "" {
+			render.BadRequestError(w, "key not specified")
 			return
 		}
 
-		filePath := filepath.Join(config.DataDir, "cache", r.URL.Query().Get("file"))
-		if !fs.Exists(filePath) {
-			render.InternalServerError(w, "file not found")
+		key := repoCacheKey(claims, r.URL.Query().Get("key"))
+		if _, err := core.CacheBlobKey(key); err != nil {
+			render.BadRequestError(w, err.Error())
 			return
 		}
 
-		file, err := os.Open(filePath)
+		var restoreKeys []string
+		for _, restoreKey := range r.URL.Query()["restore"] {
+			if restoreKey != "" {
+				restoreKeys = append(restoreKeys, repoCacheKey(claims, restoreKey))
+			}
+		}
+		key, blob, err := findCache(r.Context(), blobs, key, restoreKeys)
+		if err == core.ErrBlobNotFound {
+			render.NotFoundError(w, "cache not found")
+			return
+		}
 		if err != nil {
 			render.InternalServerError(w, err.Error())
 			return
 		}
 
-		fileHeader := make([]byte, 512)
-		if _, err := file.Read(fileHeader); err != nil {
-			render.InternalServerError(w, err.Error())
+		if r.Header.Get("Range") == "" {
+			caches.Use(key)
+		}
+
+		w.Header().Set("X-Cache-Key", strings.TrimPrefix(key, repoCacheKey(claims, "")))
+		checksum, err := readChecksum(r.Context(), blobs, blob)
+		if err == errStaleChecksum {
+			render.NotFoundError(w, "cache not found")
 			return
 		}
+		if err == nil {
+			w.Header().Set("X-Checksum", checksum)
+			w.Header().Set("ETag", fmt.Sprintf("%q", checksum))
+		}
 
-		stat, err := file.Stat()
-		if err != nil {
-			render.InternalServerError(w, err.Error())
+		if p, ok := presigner(blobs, config); ok {
+			url, err := p.PresignURL(http.MethodGet, blob.Key, presignExpiration)
+			if err != nil {
+				render.InternalServerError(w, err.Error())
+				return
+			}
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(filePath)))
-		w.Header().Set("Content-Type", http.DetectContentType(fileHeader))
-		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+		offset := rangeOffset(r, w.Header().Get("ETag"), blob.Size)
+		if offset < 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", blob.Size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
 
-		if _, err := file.Seek(0, 0); err != nil {
+		body, err := blobs.Get(r.Context(), blob.Key, offset)
+		if err != nil {
 			render.InternalServerError(w, err.Error())
 			return
 		}
+		defer body.Close()
 
-		if _, err := io.Copy(w, file); err != nil {
-			render.InternalServerError(w, err.Error())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", path.Base(blob.Key)))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size-offset, 10))
+		w.Header().Set("Last-Modified", blob.ModTime.UTC().Format(http.TimeFormat))
+		if offset > 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, blob.Size-1, blob.Size))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		if r.Method != http.MethodHead {
+			io.Copy(w, body)
+		}
+	}
+}
+
+// HandleCacheExists returns http.handlerFunc that responds with status
+// 200 when cache with key exists in the namespace of the repository of
+// the job and 404 otherwise.
+func HandleCacheExists(blobs core.BlobStore, config *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.JobClaimsFromCtx(r.Context())
+		if r.URL.Query().Get("key") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		blobKey, err := core.CacheBlobKey(repoCacheKey(claims, r.URL.Query().Get("key")))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := blobs.Stat(r.Context(), blobKey); err != nil {
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// rangeOffset returns offset of range request "bytes=offset-" for blob
+// of size, or 0 when whole blob should be sent because there is no such
+// range or If-Range precondition does not match etag. It returns -1 when
+// range is not satisfiable. Other range forms are ignored.
+func rangeOffset(r *http.Request, etag string, size int64) int64 {
+	spec := r.Header.Get("Range")
+	if !strings.HasPrefix(spec, "bytes=") || !strings.HasSuffix(spec, "-") {
+		return 0
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(spec, "bytes="), "-"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	if offset > 0 && offset >= size {
+		return -1
 	}
+	return offset
 }
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/bleenco/abstruse/internal/auth"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// HandleEnroll returns an http.HandlerFunc that writes JSON encoded
+// worker credentials to the http response body when valid enrollment
+// token is provided.
+func HandleEnroll(tokens core.EnrollmentTokenStore, identities core.WorkerIdentityStore) http.HandlerFunc {
+	type form struct {
+		ID    string `json:"id" valid:"required"`
+		Addr  string `json:"addr" valid:"required"`
+		Token string `json:"token" valid:"required"`
+	}
+
+	type resp struct {
+		Token  string `json:"token"`
+		Secret string `json:"secret"`
+		Status string `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var f form
+		defer r.Body.Close()
+
+		if err := lib.DecodeJSON(r.Body, &f); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		if valid, err := govalidator.ValidateStruct(f); err != nil || !valid {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+
+		token, err := tokens.FindToken(f.Token)
+		if err != nil {
+			render.UnathorizedError(w, "invalid enrollment token")
+			return
+		}
+
+		if err := token.Valid(); err != nil {
+			render.UnathorizedError(w, err.Error())
+			return
+		}
+
+		status := core.WorkerStatusApproved
+		if token.RequireApproval {
+			status = core.WorkerStatusPending
+		}
+
+		// credentials of enrolled workers are never rotated here, admin
+		// deletes identity of the worker to enroll it again.
+		identity, err := identities.Find(f.ID)
+		exists := err == nil
+		if exists {
+			switch identity.Status {
+			case core.WorkerStatusPending:
+				identity.Status = status
+			case core.WorkerStatusRevoked:
+				render.UnathorizedError(w, "worker identity revoked")
+				return
+			default:
+				render.ForbiddenError(w, "worker already enrolled")
+				return
+			}
+		} else {
+			identity = &core.WorkerIdentity{WorkerID: f.ID, Status: status}
+		}
+
+		if err := tokens.Use(token); err != nil {
+			render.UnathorizedError(w, err.Error())
+			return
+		}
+
+		identity.Addr = f.Addr
+		identity.TokenID = lib.RandomHexString(16)
+		identity.Secret = lib.RandomHexString(32)
+		identity.EnrollmentTokenID = token.ID
+
+		if exists {
+			err = identities.Update(identity)
+		} else {
+			err = identities.Create(identity)
+		}
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		jwt, err := auth.JWT.CreateWorkerJWT(auth.WorkerClaims{
+			ID:             identity.WorkerID,
+			Addr:           identity.Addr,
+			StandardClaims: jwt.StandardClaims{Id: identity.TokenID},
+		})
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, resp{Token: jwt, Secret: identity.Secret, Status: identity.Status})
+	}
+}
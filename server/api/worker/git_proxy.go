@@ -0,0 +1,218 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// gitUploadPack is git smart HTTP service used for fetching, pushing
+// with git-receive-pack is not proxied.
+const gitUploadPack = "git-upload-pack"
+
+// lfsBatch is git LFS batch API endpoint, only download requests are
+// proxied.
+const lfsBatch = "info/lfs/objects/batch"
+
+// gitHeaders are request headers passed to the provider by git proxy.
+var gitHeaders = []string{"Accept", "Content-Type", "Content-Encoding", "Git-Protocol", "User-Agent"}
+
+// HandleGitProxy returns http.HandlerFunc that proxies git fetch requests
+// of the job to its repository, authenticated with the provider access
+// token or, for repositories cloned over SSH, with SSH private key of the
+// repository, so neither leaves the server. Only the repository of the
+// job can be fetched and requests for pushing, including LFS uploads,
+// are rejected.
+func HandleGitProxy(jobs core.JobStore, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.CloneClaimsFromCtx(r.Context())
+		job, err := jobs.Find(claims.JobID)
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+		repo := job.Build.Repository
+
+		prefix := repo.FullName + ".git/"
+		path := chi.URLParam(r, "*")
+		if !strings.HasPrefix(path, prefix) {
+			render.NotFoundError(w, "repository not found")
+			return
+		}
+		service := strings.TrimPrefix(path, prefix)
+		switch {
+		case r.Method == http.MethodGet && service == "info/refs" && r.URL.Query().Get("service") == gitUploadPack:
+		case r.Method == http.MethodPost && service == gitUploadPack:
+		case r.Method == http.MethodPost && service == lfsBatch:
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, 10<<20))
+			if err != nil {
+				render.BadRequestError(w, err.Error())
+				return
+			}
+			var batch struct {
+				Operation string `json:"operation"`
+			}
+			if err := json.Unmarshal(body, &batch); err != nil || batch.Operation != "download" {
+				render.ForbiddenError(w, "repository can only be fetched")
+				return
+			}
+			r.Body, r.ContentLength = ioutil.NopCloser(bytes.NewReader(body)), int64(len(body))
+		default:
+			render.ForbiddenError(w, "repository can only be fetched")
+			return
+		}
+
+		upstream := repo.Clone
+		if upstream == "" {
+			upstream = repo.URL
+		}
+		var lfsHeader map[string]string
+		if repo.UseSSH {
+			if service != lfsBatch {
+				serveUploadPack(w, r, repo, logger)
+				return
+			}
+			conn, err := dialSSH(repo)
+			if err != nil {
+				logger.Errorf("error connecting to repository %s: %v", repo.FullName, err)
+				render.InternalServerError(w, err.Error())
+				return
+			}
+			upstream, lfsHeader, err = conn.lfsAuthenticate()
+			conn.Close()
+			if err != nil {
+				logger.Errorf("error authenticating to LFS of repository %s: %v", repo.FullName, err)
+				render.InternalServerError(w, err.Error())
+				return
+			}
+			service = strings.TrimPrefix(service, "info/lfs/")
+		}
+		target, err := url.Parse(strings.TrimSuffix(upstream, "/") + "/" + service)
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+		if r.Method == http.MethodGet {
+			target.RawQuery = url.Values{"service": {gitUploadPack}}.Encode()
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				header := make(http.Header)
+				for _, key := range gitHeaders {
+					if value := req.Header.Get(key); value != "" {
+						header.Set(key, value)
+					}
+				}
+				header["X-Forwarded-For"] = nil
+				req.Header = header
+				req.URL = target
+				req.Host = target.Host
+				if repo.UseSSH {
+					for key, value := range lfsHeader {
+						req.Header.Set(key, value)
+					}
+				} else if token := repo.Provider.AccessToken; token != "" {
+					req.SetBasicAuth("user", token)
+				}
+			},
+			FlushInterval: -1,
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// serveUploadPack serves git-upload-pack of the repository cloned over
+// SSH, each request is sent to new git-upload-pack on the SSH host. Git
+// protocol version 2 is stateless, with version 0 only requests ending
+// negotiation are supported, as sent by go-git.
+func serveUploadPack(w http.ResponseWriter, r *http.Request, repo *core.Repository, logger *zap.SugaredLogger) {
+	version2 := strings.Contains(r.Header.Get("Git-Protocol"), "version=2")
+	var req []byte
+	if r.Method == http.MethodPost {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				render.BadRequestError(w, err.Error())
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		var err error
+		if req, err = ioutil.ReadAll(io.LimitReader(body, 10<<20)); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+		if !version2 && !hasDone(req) {
+			render.BadRequestError(w, "negotiation without done is not supported, use git protocol version 2")
+			return
+		}
+	}
+
+	conn, err := dialSSH(repo)
+	if err != nil {
+		logger.Errorf("error connecting to repository %s: %v", repo.FullName, err)
+		render.InternalServerError(w, err.Error())
+		return
+	}
+	defer conn.Close()
+	pack, adv, err := conn.uploadPack(version2)
+	if err != nil {
+		logger.Errorf("error fetching repository %s: %v", repo.FullName, err)
+		render.InternalServerError(w, err.Error())
+		return
+	}
+	defer pack.Close()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodGet {
+		// flush-pkt ends git-upload-pack without fetching anything.
+		pack.Request([]byte(flushPkt))
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Write(encodePkt("# service=" + gitUploadPack + "\n"))
+		w.Write([]byte(flushPkt))
+		w.Write(adv)
+		return
+	}
+
+	if err := pack.Request(req); err != nil {
+		logger.Errorf("error fetching repository %s: %v", repo.FullName, err)
+		render.InternalServerError(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := pack.Response().Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logger.Errorf("error fetching repository %s: %v", repo.FullName, err)
+			return
+		}
+	}
+}
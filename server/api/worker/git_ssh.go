@@ -0,0 +1,286 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTimeout is timeout of connecting to SSH host of the repository.
+const sshTimeout = 30 * time.Second
+
+// errNoKnownHosts is returned when repository is fetched over SSH
+// without known host keys.
+var errNoKnownHosts = errors.New("no known SSH host keys, add host keys to known hosts of the repository provider")
+
+// sshRepo is connection to SSH host of the repository authenticated with
+// SSH private key of the repository.
+type sshRepo struct {
+	client *ssh.Client
+	path   string
+}
+
+// dialSSH connects to SSH host of the repository and verifies its host
+// key against known hosts of the repository provider.
+func dialSSH(repo *core.Repository) (*sshRepo, error) {
+	endpoint, err := transport.NewEndpoint(repo.CloneSSH)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Protocol != "ssh" {
+		return nil, fmt.Errorf("invalid SSH clone URL %s", repo.CloneSSH)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(repo.SSHPrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	callback, algorithms, err := hostKeyCallback([]byte(repo.Provider.KnownHosts))
+	if err != nil {
+		return nil, err
+	}
+
+	user, port := endpoint.User, endpoint.Port
+	if user == "" {
+		user = "git"
+	}
+	if port == 0 {
+		port = 22
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(endpoint.Host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback:   callback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           sshTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sshRepo{client: client, path: endpoint.Path}, nil
+}
+
+// command returns git command for the repository with its path quoted
+// for the remote shell.
+func (r *sshRepo) command(name string, args ...string) string {
+	return strings.Join(append([]string{name, "'" + strings.ReplaceAll(r.path, "'", `'\''`) + "'"}, args...), " ")
+}
+
+// uploadPack starts git-upload-pack of the repository and reads its
+// advertisement. Protocol version 2 is requested with version2, host may
+// ignore it and advertise version 0.
+func (r *sshRepo) uploadPack(version2 bool) (*uploadPack, []byte, error) {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version2 {
+		session.Setenv("GIT_PROTOCOL", "version=2")
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start(r.command("git-upload-pack")); err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+
+	p := &uploadPack{session: session, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	adv, err := p.readAdvertisement()
+	if err != nil {
+		p.Close()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, nil, fmt.Errorf("git-upload-pack: %s", msg)
+		}
+		return nil, nil, err
+	}
+	return p, adv, nil
+}
+
+// lfsAuthenticate returns URL and headers of git LFS API of the
+// repository for downloading objects.
+func (r *sshRepo) lfsAuthenticate() (string, map[string]string, error) {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return "", nil, err
+	}
+	defer session.Close()
+
+	out, err := session.Output(r.command("git-lfs-authenticate", "download"))
+	if err != nil {
+		return "", nil, fmt.Errorf("git-lfs-authenticate: %v", err)
+	}
+	var auth struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header"`
+	}
+	if err := json.Unmarshal(out, &auth); err != nil {
+		return "", nil, fmt.Errorf("git-lfs-authenticate: %v", err)
+	}
+	return auth.Href, auth.Header, nil
+}
+
+func (r *sshRepo) Close() error {
+	return r.client.Close()
+}
+
+// uploadPack is git-upload-pack running on SSH host of the repository.
+type uploadPack struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+// readAdvertisement reads pkt-lines advertised by git-upload-pack up to
+// and including flush-pkt.
+func (p *uploadPack) readAdvertisement() ([]byte, error) {
+	var adv bytes.Buffer
+	for {
+		pkt, err := readPkt(p.stdout)
+		if err != nil {
+			return nil, err
+		}
+		adv.Write(pkt)
+		if string(pkt) == flushPkt {
+			return adv.Bytes(), nil
+		}
+	}
+}
+
+// Request sends request to git-upload-pack and closes its input, so it
+// exits once it responds. Response is read from Response.
+func (p *uploadPack) Request(req []byte) error {
+	if _, err := p.stdin.Write(req); err != nil {
+		return err
+	}
+	return p.stdin.Close()
+}
+
+// Response returns reader of the response of git-upload-pack.
+func (p *uploadPack) Response() io.Reader {
+	return p.stdout
+}
+
+func (p *uploadPack) Close() error {
+	return p.session.Close()
+}
+
+// flushPkt ends pkt-line messages.
+const flushPkt = "0000"
+
+// readPkt reads pkt-line, flush-pkt or delim-pkt including its length.
+func readPkt(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line length %q", header)
+	}
+	if length < 4 {
+		return header, nil
+	}
+	pkt := make([]byte, length)
+	copy(pkt, header)
+	if _, err := io.ReadFull(r, pkt[4:]); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// encodePkt returns data encoded as pkt-line.
+func encodePkt(data string) []byte {
+	return []byte(fmt.Sprintf("%04x%s", len(data)+4, data))
+}
+
+// hasDone reports whether request of git protocol version 0 ends
+// negotiation with done, so git-upload-pack responds with packfile.
+func hasDone(req []byte) bool {
+	r := bufio.NewReader(bytes.NewReader(req))
+	for {
+		pkt, err := readPkt(r)
+		if err != nil {
+			return false
+		}
+		if len(pkt) > 4 && strings.TrimSuffix(string(pkt[4:]), "\n") == "done" {
+			return true
+		}
+	}
+}
+
+// hostKeyCallback returns callback verifying host keys against known
+// hosts and host key algorithms of known keys, so host presents a key
+// that is known.
+func hostKeyCallback(knownHosts []byte) (ssh.HostKeyCallback, []string, error) {
+	if len(bytes.TrimSpace(knownHosts)) == 0 {
+		return nil, nil, errNoKnownHosts
+	}
+
+	var algorithms []string
+	for rest := knownHosts; len(rest) > 0; {
+		_, _, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid known hosts: %v", err)
+		}
+		if !lib.Include(algorithms, key.Type()) {
+			algorithms = append(algorithms, key.Type())
+		}
+		rest = next
+	}
+
+	f, err := ioutil.TempFile("", "abstruse-known-hosts-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(knownHosts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) > 0 {
+				return fmt.Errorf("host key verification failed for %s: %s key %s does not match known host key, possible man-in-the-middle attack", hostname, key.Type(), ssh.FingerprintSHA256(key))
+			}
+			return fmt.Errorf("host key verification failed for %s: %s key %s is not known, add it to known hosts of the repository provider", hostname, key.Type(), ssh.FingerprintSHA256(key))
+		}
+		return err
+	}, algorithms, nil
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// HandleListIdentities returns an http.HandlerFunc that writes JSON encoded
+// list of enrolled worker identities to the http response body.
+func HandleListIdentities(identities core.WorkerIdentityStore, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		list, err := identities.List()
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		render.JSON(w, http.StatusOK, list)
+	}
+}
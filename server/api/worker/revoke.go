@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/bleenco/abstruse/server/api/middlewares"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
+)
+
+// HandleRevoke returns an http.HandlerFunc that writes JSON encoded
+// result about revoking worker identity to the http response body.
+// Revoked worker is disconnected and cannot authenticate anymore.
+func HandleRevoke(identities core.WorkerIdentityStore, workers core.WorkerRegistry, users core.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middlewares.ClaimsFromCtx(r.Context())
+
+		if user, err := users.Find(claims.ID); err != nil || user.Role != "admin" {
+			render.UnathorizedError(w, "permission denied")
+			return
+		}
+
+		identity, err := identities.Find(chi.URLParam(r, "id"))
+		if err != nil {
+			render.NotFoundError(w, err.Error())
+			return
+		}
+
+		identity.Status = core.WorkerStatusRevoked
+		identity.TokenID = ""
+		identity.Secret = ""
+		if err := identities.Update(identity); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		disconnect(workers, identity.WorkerID)
+
+		render.JSON(w, http.StatusOK, identity)
+	}
+}
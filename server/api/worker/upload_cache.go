@@ -1,37 +1,253 @@
 package worker
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bleenco/abstruse/internal/auth"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/api/middlewares"
 	"github.com/bleenco/abstruse/server/api/render"
 	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/go-chi/chi"
 )
 
-// HandleUploadCache returns http.handlerFunc that writes JSON encoded
-// result about uploading cache to the http response body.
-func HandleUploadCache(config *config.Config) http.HandlerFunc {
+// maxChunkSize is maximum size of cache upload chunk.
+const maxChunkSize = 64 << 20
+
+// uploadExpiration is time after which unfinished uploads are removed.
+const uploadExpiration = 24 * time.Hour
+
+type uploadResponse struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Presign bool   `json:"presign,omitempty"`
+}
+
+type urlResponse struct {
+	URL string `json:"url"`
+}
+
+// uploadSession is cache upload stored as blob with its chunks.
+type uploadSession struct {
+	Key          string `json:"key"`
+	RepositoryID uint   `json:"repositoryID"`
+}
+
+// uploadChunk is uploaded part of the cache archive stored as blob.
+type uploadChunk struct {
+	offset int64
+	blob   *core.Blob
+}
+
+// HandleCreateCacheUpload returns http.handlerFunc that starts upload
+// of cache with key in the namespace of the repository of the job and
+// writes JSON encoded upload ID to the http response body. Unfinished
+// uploads older than a day are removed.
+func HandleCreateCacheUpload(blobs core.BlobStore, config *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.ParseMultipartForm(1000 << 20)
+		claims := middlewares.JobClaimsFromCtx(r.Context())
+		if r.URL.Query().Get("key") == "" {
+			render.BadRequestError(w, "key not specified")
+			return
+		}
+		key := repoCacheKey(claims, r.URL.Query().Get("key"))
+		if _, err := core.CacheBlobKey(key); err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+		removeExpiredUploads(r.Context(), blobs)
 
-		src, handler, err := r.FormFile("file")
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+		id := hex.EncodeToString(b)
+
+		session, err := json.Marshal(uploadSession{Key: key, RepositoryID: claims.RepoID})
 		if err != nil {
 			render.InternalServerError(w, err.Error())
 			return
 		}
-		defer src.Close()
+		if err := blobs.Put(r.Context(), uploadPrefix+id+"/session", bytes.NewReader(session), int64(len(session))); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		_, presign := presigner(blobs, config)
+		render.JSON(w, http.StatusOK, uploadResponse{ID: id, Presign: presign})
+	}
+}
 
-		filePath := filepath.Join(config.DataDir, "cache", handler.Filename)
-		dst, err := os.Create(filePath)
+// HandleUploadCacheChunk returns http.handlerFunc that stores chunk of
+// the upload at offset and writes JSON encoded offset of the upload to
+// the http response body. Chunk is verified against its SHA-256 checksum
+// in X-Checksum header. Writing a chunk again at the same offset
+// replaces it and all chunks after it, so chunks of interrupted requests
+// can be retried.
+func HandleUploadCacheChunk(blobs core.BlobStore, config *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if _, err := findUpload(r.Context(), blobs, id, middlewares.JobClaimsFromCtx(r.Context())); err != nil {
+			render.NotFoundError(w, "upload not found")
+			return
+		}
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			render.BadRequestError(w, "invalid offset")
+			return
+		}
+		if r.ContentLength < 0 || r.ContentLength > maxChunkSize {
+			render.BadRequestError(w, fmt.Sprintf("chunk size must be known and at most %d bytes", maxChunkSize))
+			return
+		}
+		if !prepareChunk(w, r, blobs, id, offset) {
+			return
+		}
+
+		chunkKey := uploadChunkKey(id, offset)
+		h := sha256.New()
+		if err := blobs.Put(r.Context(), chunkKey, io.TeeReader(r.Body, h), r.ContentLength); err != nil {
+			blobs.Delete(r.Context(), chunkKey)
+			render.BadRequestError(w, err.Error())
+			return
+		}
+		if hex.EncodeToString(h.Sum(nil)) != r.Header.Get("X-Checksum") {
+			blobs.Delete(r.Context(), chunkKey)
+			render.BadRequestError(w, "chunk checksum mismatch")
+			return
+		}
+
+		render.JSON(w, http.StatusOK, uploadResponse{ID: id, Offset: offset + r.ContentLength})
+	}
+}
+
+// HandleCacheChunkURL returns http.handlerFunc that writes JSON encoded
+// presigned URL which worker uses to upload chunk of the upload at
+// offset directly to the storage. Chunks after offset are removed. Storage
+// does not limit size of the chunk, so chunks larger than maxChunkSize
+// are rejected when next chunk is prepared and when upload is completed.
+func HandleCacheChunkURL(blobs core.BlobStore, config *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := presigner(blobs, config)
+		if !ok {
+			render.BadRequestError(w, "presigned urls are not enabled")
+			return
+		}
+		id := chi.URLParam(r, "id")
+		if _, err := findUpload(r.Context(), blobs, id, middlewares.JobClaimsFromCtx(r.Context())); err != nil {
+			render.NotFoundError(w, "upload not found")
+			return
+		}
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			render.BadRequestError(w, "invalid offset")
+			return
+		}
+		if !prepareChunk(w, r, blobs, id, offset) {
+			return
+		}
+
+		url, err := p.PresignURL(http.MethodPut, uploadChunkKey(id, offset), presignExpiration)
 		if err != nil {
 			render.InternalServerError(w, err.Error())
 			return
 		}
-		defer dst.Close()
+		render.JSON(w, http.StatusOK, urlResponse{URL: url})
+	}
+}
 
-		if _, err := io.Copy(dst, src); err != nil {
+// HandleCompleteCacheUpload returns http.handlerFunc that joins chunks
+// of the upload into archive stored with the upload and verifies its
+// size and SHA-256 checksum. Verified archive is then moved to the cache
+// with the key of the upload, existing cache with the same key is
+// replaced. Cache is added to the index, which evicts least recently
+// used caches over quotas.
+func HandleCompleteCacheUpload(blobs core.BlobStore, caches core.CacheService, config *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		upload, err := findUpload(r.Context(), blobs, id, middlewares.JobClaimsFromCtx(r.Context()))
+		if err != nil {
+			render.NotFoundError(w, "upload not found")
+			return
+		}
+		blobKey, err := core.CacheBlobKey(upload.Key)
+		if err != nil {
+			render.BadRequestError(w, err.Error())
+			return
+		}
+		chunks, err := uploadChunks(r.Context(), blobs, id)
+		if err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+
+		var size int64
+		for _, c := range chunks {
+			if c.blob.Size > maxChunkSize {
+				removeUpload(r.Context(), blobs, id)
+				render.BadRequestError(w, fmt.Sprintf("chunk at offset %d is larger than %d bytes", c.offset, maxChunkSize))
+				return
+			}
+			if c.offset != size {
+				render.BadRequestError(w, fmt.Sprintf("upload is missing data at offset %d", size))
+				return
+			}
+			size += c.blob.Size
+		}
+		if strconv.FormatInt(size, 10) != r.URL.Query().Get("size") {
+			removeUpload(r.Context(), blobs, id)
+			render.BadRequestError(w, "upload size or checksum mismatch")
+			return
+		}
+
+		archiveKey := uploadPrefix + id + "/archive"
+		h := sha256.New()
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(joinChunks(r.Context(), blobs, chunks, pw))
+		}()
+		err = blobs.Put(r.Context(), archiveKey, io.TeeReader(pr, h), size)
+		pr.Close()
+		if err != nil {
+			removeUpload(r.Context(), blobs, id)
+			render.InternalServerError(w, err.Error())
+			return
+		}
+		checksum := hex.EncodeToString(h.Sum(nil))
+		if checksum != r.URL.Query().Get("checksum") {
+			removeUpload(r.Context(), blobs, id)
+			render.BadRequestError(w, "upload size or checksum mismatch")
+			return
+		}
+
+		// checksum is written after the archive, downloads ignore cache
+		// with checksum older than its archive until then.
+		if err := blobs.Move(r.Context(), archiveKey, blobKey); err != nil {
+			removeUpload(r.Context(), blobs, id)
+			render.InternalServerError(w, err.Error())
+			return
+		}
+		if err := blobs.Put(r.Context(), blobKey+core.CacheChecksumExt, strings.NewReader(checksum), int64(len(checksum))); err != nil {
+			render.InternalServerError(w, err.Error())
+			return
+		}
+		removeUpload(r.Context(), blobs, id)
+
+		if err := caches.Add(r.Context(), upload.RepositoryID, upload.Key, size); err != nil {
 			render.InternalServerError(w, err.Error())
 			return
 		}
@@ -39,3 +255,117 @@ func HandleUploadCache(config *config.Config) http.HandlerFunc {
 		render.JSON(w, http.StatusOK, render.BoolResponse{Status: true})
 	}
 }
+
+// prepareChunk removes chunks of the upload at or after offset and
+// chunks larger than maxChunkSize, then checks that offset is at the end
+// of remaining chunks, it responds with status 409 otherwise.
+func prepareChunk(w http.ResponseWriter, r *http.Request, blobs core.BlobStore, id string, offset int64) bool {
+	chunks, err := uploadChunks(r.Context(), blobs, id)
+	if err != nil {
+		render.InternalServerError(w, err.Error())
+		return false
+	}
+	var end int64
+	for _, c := range chunks {
+		if c.offset >= offset || c.blob.Size > maxChunkSize {
+			if err := blobs.Delete(r.Context(), c.blob.Key); err != nil && err != core.ErrBlobNotFound {
+				render.InternalServerError(w, err.Error())
+				return false
+			}
+			continue
+		}
+		if c.offset == end {
+			end += c.blob.Size
+		}
+	}
+	if offset != end {
+		render.JSON(w, http.StatusConflict, render.Error{Message: fmt.Sprintf("offset %d is not at the end of upload at %d", offset, end)})
+		return false
+	}
+	return true
+}
+
+// findUpload returns the upload with id started by job of the same
+// repository.
+func findUpload(ctx context.Context, blobs core.BlobStore, id string, claims auth.JobClaims) (*uploadSession, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, core.ErrBlobNotFound
+	}
+	r, err := blobs.Get(ctx, uploadPrefix+id+"/session", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	upload := &uploadSession{}
+	if err := lib.DecodeJSON(io.LimitReader(r, 4096), upload); err != nil {
+		return nil, err
+	}
+	if upload.RepositoryID != claims.RepoID {
+		return nil, core.ErrBlobNotFound
+	}
+	return upload, nil
+}
+
+// uploadChunkKey returns blob key of the chunk of the upload at offset,
+// offset is zero padded so chunks are listed in order.
+func uploadChunkKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%s/%020d", uploadPrefix, id, offset)
+}
+
+// uploadChunks returns chunks of the upload ordered by offset.
+func uploadChunks(ctx context.Context, blobs core.BlobStore, id string) ([]uploadChunk, error) {
+	list, err := blobs.List(ctx, uploadPrefix+id+"/")
+	if err != nil {
+		return nil, err
+	}
+	var chunks []uploadChunk
+	for _, blob := range list {
+		offset, err := strconv.ParseInt(strings.TrimPrefix(blob.Key, uploadPrefix+id+"/"), 10, 64)
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, uploadChunk{offset: offset, blob: blob})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].offset < chunks[j].offset })
+	return chunks, nil
+}
+
+// joinChunks writes contents of chunks to w in order.
+func joinChunks(ctx context.Context, blobs core.BlobStore, chunks []uploadChunk, w io.Writer) error {
+	for _, c := range chunks {
+		r, err := blobs.Get(ctx, c.blob.Key, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUpload removes session and chunks of the upload with id.
+func removeUpload(ctx context.Context, blobs core.BlobStore, id string) {
+	list, err := blobs.List(ctx, uploadPrefix+id+"/")
+	if err != nil {
+		return
+	}
+	for _, blob := range list {
+		blobs.Delete(ctx, blob.Key)
+	}
+}
+
+// removeExpiredUploads removes blobs of unfinished uploads.
+func removeExpiredUploads(ctx context.Context, blobs core.BlobStore) {
+	list, err := blobs.List(ctx, uploadPrefix)
+	if err != nil {
+		return
+	}
+	for _, blob := range list {
+		if time.Since(blob.ModTime) > uploadExpiration {
+			blobs.Delete(ctx, blob.Key)
+		}
+	}
+}
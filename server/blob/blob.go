@@ -0,0 +1,32 @@
+package blob
+
+import (
+	"fmt"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// Available storage drivers.
+const (
+	FS = "fs"
+	S3 = "s3"
+)
+
+// New returns blob store of configured storage driver. Filesystem
+// driver stores blobs in data directory.
+func New(config *config.Config) (core.BlobStore, error) {
+	driver := FS
+	if config.Storage != nil && config.Storage.Driver != "" {
+		driver = config.Storage.Driver
+	}
+
+	switch driver {
+	case FS:
+		return newFSStore(config.DataDir), nil
+	case S3:
+		return newS3Store(config.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", driver)
+	}
+}
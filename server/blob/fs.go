@@ -0,0 +1,177 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// tempPrefix is name prefix of files being written.
+const tempPrefix = ".put-"
+
+// fsStore stores blobs as files in directory.
+type fsStore struct {
+	root string
+}
+
+func newFSStore(root string) *fsStore {
+	return &fsStore{root: filepath.Clean(root)}
+}
+
+func (s *fsStore) Stat(ctx context.Context, key string) (*core.Blob, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) || err == nil && info.IsDir() {
+		return nil, core.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &core.Blob{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *fsStore) List(ctx context.Context, prefix string) ([]*core.Blob, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		dir = prefix[:i]
+	}
+	root, err := s.path(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []*core.Blob
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), tempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, &core.Blob{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	return blobs, err
+}
+
+func (s *fsStore) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, core.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Put writes blob into temporary file first, so readers never see
+// incomplete blob.
+func (s *fsStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(p), tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	n, err := io.Copy(file, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("blob %s: wrote %d bytes, expected %d", key, n, size)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), p)
+}
+
+// Move renames the file of the blob, so readers see either old or new
+// blob.
+func (s *fsStore) Move(ctx context.Context, src, dst string) error {
+	sp, err := s.path(src)
+	if err != nil {
+		return err
+	}
+	dp, err := s.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dp), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(sp, dp); err != nil {
+		if os.IsNotExist(err) {
+			return core.ErrBlobNotFound
+		}
+		return err
+	}
+	s.removeEmptyDirs(sp)
+	return nil
+}
+
+// Delete removes the file of the blob and parent directories left empty.
+func (s *fsStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.removeEmptyDirs(p)
+	return nil
+}
+
+// removeEmptyDirs removes parent directories of path left empty.
+func (s *fsStore) removeEmptyDirs(p string) {
+	for dir := filepath.Dir(p); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// path returns path of the file of blob with key.
+func (s *fsStore) path(key string) (string, error) {
+	if key != path.Clean("/" + key)[1:] {
+		return "", fmt.Errorf("invalid blob key %s", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSPath(t *testing.T) {
+	root := t.TempDir()
+	s := newFSStore(filepath.Join(root, "data"))
+
+	for _, key := range []string{"../x", "a/../../x", "cache/../../x", "/etc/passwd", "a//b", "a/./b", "a/", ".."} {
+		if p, err := s.path(key); err == nil {
+			t.Errorf("path(%q) = %s, want error", key, p)
+		}
+		if err := s.Put(context.Background(), key, strings.NewReader("x"), 1); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of root: %v", err)
+	}
+
+	p, err := s.path("cache/a%2F..%2Fb+c.tgz")
+	if err != nil {
+		t.Fatalf("path() error = %v", err)
+	}
+	if want := filepath.Join(root, "data", "cache", "a%2F..%2Fb+c.tgz"); p != want {
+		t.Errorf("path() = %s, want %s", p, want)
+	}
+}
@@ -0,0 +1,240 @@
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// s3Store stores blobs in S3 compatible object storage bucket.
+type s3Store struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	pathStyle bool
+	signer    *signer
+	now       func() time.Time
+}
+
+func newS3Store(config *config.StorageS3) (*s3Store, error) {
+	if config == nil || config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket not specified")
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", config.Endpoint)
+	}
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Store{
+		client:    http.DefaultClient,
+		endpoint:  endpoint,
+		bucket:    config.Bucket,
+		prefix:    strings.Trim(config.Prefix, "/"),
+		pathStyle: config.PathStyle,
+		signer:    &signer{accessKey: config.AccessKey, secretKey: config.SecretKey, region: region},
+		now:       time.Now,
+	}, nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (*core.Blob, error) {
+	resp, err := s.do(ctx, "HEAD", key, nil, nil, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &core.Blob{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]*core.Blob, error) {
+	var blobs []*core.Blob
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, "GET", "", query, nil, -1, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, s.key(""))
+			blobs = append(blobs, &core.Blob{Key: key, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated {
+			return blobs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, "GET", key, nil, header, -1, nil)
+	if err != nil {
+		if e, ok := err.(*s3Error); ok && e.status == http.StatusRequestedRangeNotSatisfiable {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, "PUT", key, nil, nil, size, r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Move copies the object to dst and deletes it, S3 has no rename.
+func (s *s3Store) Move(ctx context.Context, src, dst string) error {
+	segments := strings.Split(s.bucket+"/"+s.key(src), "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", strings.Join(segments, "/"))
+	resp, err := s.do(ctx, "PUT", dst, nil, header, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return s.Delete(ctx, src)
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", key, nil, nil, -1, nil)
+	if err != nil {
+		if err == core.ErrBlobNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignURL returns presigned URL of the object.
+func (s *s3Store) PresignURL(method, key string, expires time.Duration) (string, error) {
+	return s.signer.presign(method, s.url(key, nil), expires, s.now()), nil
+}
+
+// do sends signed request for object with key, or bucket when key is
+// empty. Body of size bytes is sent when size is not negative.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, size int64, body io.Reader) (*http.Response, error) {
+	u := s.url(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	s.signer.sign(req, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, core.ErrBlobNotFound
+		}
+		var e struct {
+			Code    string
+			Message string
+		}
+		xml.NewDecoder(resp.Body).Decode(&e)
+		return nil, &s3Error{status: resp.StatusCode, code: e.Code, message: e.Message}
+	}
+	return resp, nil
+}
+
+// url returns URL of the object with key, or bucket when key is empty.
+func (s *s3Store) url(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := ""
+	if key != "" {
+		path = "/" + s.key(key)
+	}
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + path
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + path
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+	// path is sent encoded the same way it is signed
+	u.RawPath = canonicalURI(&u)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// key returns object key of blob with key.
+func (s *s3Store) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *s3Error) Error() string {
+	if e.code == "" {
+		return "s3 responded with status " + strconv.Itoa(e.status)
+	}
+	return fmt.Sprintf("s3 error %s: %s", e.code, e.message)
+}
@@ -0,0 +1,545 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "abstruse"
+)
+
+var testTime = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// s3StandIn is path style S3 compatible server which checks signatures
+// of requests and keeps objects in memory.
+type s3StandIn struct {
+	t        *testing.T
+	pageSize int
+
+	mu       sync.Mutex
+	now      time.Time
+	objects  map[string][]byte
+	listings int
+}
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *httptest.Server) {
+	s := &s3StandIn{t: t, pageSize: 1000, now: testTime, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		s.t.Logf("%s %s: %v", r.Method, r.URL.EscapedPath(), err)
+		s.error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/"+testBucket || r.URL.Path == "/"+testBucket+"/" {
+		s.list(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	data, ok := s.objects[key]
+
+	switch r.Method {
+	case http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", s.now.Format(http.TimeFormat))
+	case http.MethodGet:
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey", "object not found")
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || offset >= len(data) {
+				s.error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "range not satisfiable")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[offset:])
+			return
+		}
+		w.Write(data)
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			src, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+			if err != nil || !strings.HasPrefix(src, testBucket+"/") {
+				s.error(w, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+				return
+			}
+			data, ok := s.objects[strings.TrimPrefix(src, testBucket+"/")]
+			if !ok {
+				s.error(w, http.StatusNotFound, "NoSuchKey", "copy source not found")
+				return
+			}
+			s.objects[key] = data
+			fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			s.error(w, http.StatusBadRequest, "IncompleteBody", "body does not match content length")
+			return
+		}
+		s.objects[key] = body
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// list responds with one page of objects, continuation token is the key
+// of the last object of the previous page.
+func (s *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		s.error(w, http.StatusBadRequest, "InvalidArgument", "list-type")
+		return
+	}
+	s.listings++
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	if len(keys) > s.pageSize {
+		keys = keys[:s.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, object{key, len(s.objects[key]), s.now})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3StandIn) error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+// verify checks signature of the request in authorization header or in
+// query string of presigned URL.
+func (s *s3StandIn) verify(r *http.Request) error {
+	path := r.URL.EscapedPath()
+	if want := awsEncodePath(r.URL.Path); path != want {
+		return fmt.Errorf("path %s is not encoded as %s", path, want)
+	}
+
+	query := r.URL.Query()
+	if query.Get("X-Amz-Signature") != "" {
+		return s.verifyPresigned(r, path, query)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("unsigned request")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("invalid date: %v", err)
+	}
+	if want := testAccessKey + "/" + testScope(date); fields["Credential"] != want {
+		return fmt.Errorf("credential %s, want %s", fields["Credential"], want)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if (name == "range" || strings.HasPrefix(name, "x-amz-")) && !contains(signed, name) {
+			return fmt.Errorf("header %s not signed", name)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		path,
+		awsEncodeQuery(query),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	if want := testSignature(date, canonical); fields["Signature"] != want {
+		return fmt.Errorf("signature mismatch, canonical request:\n%s", canonical)
+	}
+	return nil
+}
+
+func (s *s3StandIn) verifyPresigned(r *http.Request, path string, query url.Values) error {
+	date, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("invalid date: %v", err)
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return fmt.Errorf("invalid expires: %v", err)
+	}
+	s.mu.Lock()
+	now := s.now
+	s.mu.Unlock()
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return fmt.Errorf("request has expired")
+	}
+	if query.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" || query.Get("X-Amz-SignedHeaders") != "host" {
+		return fmt.Errorf("invalid algorithm or signed headers")
+	}
+	if want := testAccessKey + "/" + testScope(date); query.Get("X-Amz-Credential") != want {
+		return fmt.Errorf("credential %s, want %s", query.Get("X-Amz-Credential"), want)
+	}
+
+	signature := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+	canonical := strings.Join([]string{
+		r.Method,
+		path,
+		awsEncodeQuery(query),
+		"host:" + r.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	if signature != testSignature(date, canonical) {
+		return fmt.Errorf("signature mismatch, canonical request:\n%s", canonical)
+	}
+	return nil
+}
+
+func testScope(date time.Time) string {
+	return date.Format("20060102") + "/" + testRegion + "/s3/aws4_request"
+}
+
+func testSignature(date time.Time, canonical string) string {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + date.Format("20060102T150405Z") + "\n" + testScope(date) + "\n" + hex.EncodeToString(hash[:])
+	key := mac([]byte("AWS4"+testSecretKey), date.Format("20060102"))
+	key = mac(key, testRegion)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	return hex.EncodeToString(mac(key, stringToSign))
+}
+
+// awsEncode percent encodes every byte except letters, digits and -_.~
+func awsEncode(s string) string {
+	const unreserved = "-_.~"
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte(unreserved, c) != -1:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func awsEncodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = awsEncode(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func awsEncodeQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsEncode(name)+"="+awsEncode(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestS3Store(t *testing.T, endpoint, secretKey string) *s3Store {
+	s, err := newS3Store(&config.StorageS3{
+		Endpoint:  endpoint,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		Prefix:    "/ci/",
+		AccessKey: testAccessKey,
+		SecretKey: secretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("newS3Store() error = %v", err)
+	}
+	s.now = func() time.Time { return testTime }
+	return s
+}
+
+func TestS3PutGetStat(t *testing.T) {
+	standIn, srv := newS3StandIn(t)
+	s := newTestS3Store(t, srv.URL, testSecretKey)
+	ctx := context.Background()
+
+	key, err := core.CacheBlobKey("deps/go+mod 100%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(key, "%") || !strings.Contains(key, "+") {
+		t.Fatalf("key %s does not contain %% and +", key)
+	}
+
+	data := []byte("cache archive")
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, ok := standIn.objects["ci/"+key]; !ok {
+		t.Fatalf("object ci/%s not stored, objects: %v", key, standIn.objects)
+	}
+
+	blob, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if blob.Key != key || blob.Size != int64(len(data)) || !blob.ModTime.Equal(testTime) {
+		t.Errorf("Stat() = %+v", blob)
+	}
+
+	for _, tt := range []struct {
+		offset int64
+		want   string
+	}{
+		{0, "cache archive"},
+		{6, "archive"},
+		{int64(len(data)), ""},
+	} {
+		r, err := s.Get(ctx, key, tt.offset)
+		if err != nil {
+			t.Fatalf("Get(%d) error = %v", tt.offset, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Get(%d) = %q, %v, want %q", tt.offset, got, err, tt.want)
+		}
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if len(standIn.objects) != 0 {
+		t.Errorf("objects after Delete() = %v", standIn.objects)
+	}
+}
+
+func TestS3NotFound(t *testing.T) {
+	_, srv := newS3StandIn(t)
+	s := newTestS3Store(t, srv.URL, testSecretKey)
+	ctx := context.Background()
+
+	if _, err := s.Stat(ctx, "missing"); err != core.ErrBlobNotFound {
+		t.Errorf("Stat() error = %v, want ErrBlobNotFound", err)
+	}
+	if _, err := s.Get(ctx, "missing", 0); err != core.ErrBlobNotFound {
+		t.Errorf("Get() error = %v, want ErrBlobNotFound", err)
+	}
+	if err := s.Move(ctx, "missing", "other"); err != core.ErrBlobNotFound {
+		t.Errorf("Move() error = %v, want ErrBlobNotFound", err)
+	}
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+}
+
+func TestS3SignatureMismatch(t *testing.T) {
+	_, srv := newS3StandIn(t)
+	s := newTestS3Store(t, srv.URL, "wrong")
+
+	err := s.Put(context.Background(), "key", strings.NewReader("data"), 4)
+	if e, ok := err.(*s3Error); !ok || e.code != "SignatureDoesNotMatch" {
+		t.Errorf("Put() error = %v, want SignatureDoesNotMatch", err)
+	}
+}
+
+func TestS3ListPagination(t *testing.T) {
+	standIn, srv := newS3StandIn(t)
+	standIn.pageSize = 2
+	s := newTestS3Store(t, srv.URL, testSecretKey)
+	ctx := context.Background()
+
+	want := []string{"cache/a%2Bb.tgz", "cache/b.tgz", "cache/c+d.tgz", "cache/d.tgz", "cache/e.tgz"}
+	for _, key := range append(want, "uploads/cache/1/0") {
+		if err := s.Put(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+
+	blobs, err := s.List(ctx, "cache/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var got []string
+	for _, blob := range blobs {
+		got = append(got, blob.Key)
+		if blob.Size != int64(len(blob.Key)) {
+			t.Errorf("blob %s size = %d, want %d", blob.Key, blob.Size, len(blob.Key))
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	if standIn.listings != 3 {
+		t.Errorf("list requests = %d, want 3", standIn.listings)
+	}
+}
+
+func TestS3Move(t *testing.T) {
+	standIn, srv := newS3StandIn(t)
+	s := newTestS3Store(t, srv.URL, testSecretKey)
+	ctx := context.Background()
+
+	src, dst := "uploads/cache/1/archive", "cache/a%2Fb+c.tgz"
+	if err := s.Put(ctx, src, strings.NewReader("new"), 3); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Move(ctx, src, dst); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if _, ok := standIn.objects["ci/"+src]; ok {
+		t.Error("source not deleted")
+	}
+	if got := string(standIn.objects["ci/"+dst]); got != "new" {
+		t.Errorf("destination = %q, want new", got)
+	}
+}
+
+func TestS3PresignURL(t *testing.T) {
+	standIn, srv := newS3StandIn(t)
+	s := newTestS3Store(t, srv.URL, testSecretKey)
+	key := "cache/go+mod%2F100%25.tgz"
+
+	put, err := s.PresignURL(http.MethodPut, key, time.Hour)
+	if err != nil {
+		t.Fatalf("PresignURL(PUT) error = %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, put, strings.NewReader("presigned"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT status = %d", resp.StatusCode)
+	}
+
+	get, err := s.PresignURL(http.MethodGet, key, time.Hour)
+	if err != nil {
+		t.Fatalf("PresignURL(GET) error = %v", err)
+	}
+	resp, err = http.Get(get)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "presigned" {
+		t.Errorf("GET = %d %q, want 200 presigned", resp.StatusCode, body)
+	}
+
+	if resp, err := http.Get(strings.Replace(get, "X-Amz-Expires=3600", "X-Amz-Expires=7200", 1)); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET with modified query succeeded")
+	}
+
+	standIn.mu.Lock()
+	standIn.now = testTime.Add(2 * time.Hour)
+	standIn.mu.Unlock()
+	if resp, err := http.Get(get); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET of expired URL succeeded")
+	}
+}
+
+func TestS3VirtualHostedURL(t *testing.T) {
+	s, err := newS3Store(&config.StorageS3{Endpoint: "https://s3.example.com", Bucket: testBucket, Prefix: "ci"})
+	if err != nil {
+		t.Fatalf("newS3Store() error = %v", err)
+	}
+	for key, want := range map[string]string{
+		"":                    "https://abstruse.s3.example.com/",
+		"cache/a%2Fb+c d.tgz": "https://abstruse.s3.example.com/ci/cache/a%252Fb%2Bc%20d.tgz",
+	} {
+		if got := s.url(key, nil).String(); got != want {
+			t.Errorf("url(%q) = %s, want %s", key, got, want)
+		}
+	}
+}
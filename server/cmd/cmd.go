@@ -101,6 +101,8 @@ func init() {
 	rootCmd.PersistentFlags().String("websocket-addr", "127.0.0.1:2220", "WebSocket server listen address")
 	rootCmd.PersistentFlags().String("tls-cert", "cert.pem", "path to SSL certificate file")
 	rootCmd.PersistentFlags().String("tls-key", "key.pem", "path to SSL private key file")
+	rootCmd.PersistentFlags().String("tls-ca-cert", "ca.pem", "path to CA certificate file used to sign worker certificates")
+	rootCmd.PersistentFlags().String("tls-ca-key", "ca-key.pem", "path to CA private key file used to sign worker certificates")
 	rootCmd.PersistentFlags().String("db-driver", "mysql", "database client (available options: mysql, postgres, mssql)")
 	rootCmd.PersistentFlags().String("db-host", "localhost", "database server host address")
 	rootCmd.PersistentFlags().Int("db-port", 3306, "database server port")
@@ -116,6 +118,32 @@ func init() {
 	rootCmd.PersistentFlags().Int("logger-max-age", 3, "maximum log age")
 	rootCmd.PersistentFlags().String("auth-jwtsecret", lib.RandomString(), "JWT authentication secret key")
 	rootCmd.PersistentFlags().String("datadir", "data/", "Directory to store build cache and build artifacts")
+	rootCmd.PersistentFlags().StringSlice("plugins-allowed", []string{}, "plugin image patterns steps are allowed to use (e.g. plugins/*), all plugins are allowed when empty")
+	rootCmd.PersistentFlags().String("cache-quota", "10GB", "total size of caches above which least recently used caches are evicted (0 is unlimited)")
+	rootCmd.PersistentFlags().String("cache-repo-quota", "0", "size of caches of a repository above which its least recently used caches are evicted (0 is unlimited)")
+	rootCmd.PersistentFlags().String("storage-driver", "fs", "storage of caches (available options: fs, s3), fs stores them in datadir")
+	rootCmd.PersistentFlags().Bool("storage-presign", false, "let workers download and upload caches directly with presigned URLs (s3 driver)")
+	rootCmd.PersistentFlags().String("storage-s3-endpoint", "https://s3.amazonaws.com", "S3 compatible storage endpoint")
+	rootCmd.PersistentFlags().String("storage-s3-region", "us-east-1", "S3 region")
+	rootCmd.PersistentFlags().String("storage-s3-bucket", "", "S3 bucket")
+	rootCmd.PersistentFlags().String("storage-s3-prefix", "", "S3 object key prefix")
+	rootCmd.PersistentFlags().String("storage-s3-accesskey", "", "S3 access key")
+	rootCmd.PersistentFlags().String("storage-s3-secretkey", "", "S3 secret key")
+	rootCmd.PersistentFlags().Bool("storage-s3-pathstyle", false, "use path style bucket URLs (e.g. for MinIO)")
+	rootCmd.PersistentFlags().String("autoscaler-provider", "", "worker autoscaler provider (available options: command, docker), disabled when empty")
+	rootCmd.PersistentFlags().Int("autoscaler-min", 0, "minimum number of worker nodes")
+	rootCmd.PersistentFlags().Int("autoscaler-max", 5, "maximum number of worker nodes")
+	rootCmd.PersistentFlags().Int("autoscaler-interval", 30, "interval in seconds between autoscaler checks")
+	rootCmd.PersistentFlags().Int("autoscaler-scaleout-cooldown", 120, "time in seconds to wait after adding worker nodes")
+	rootCmd.PersistentFlags().Int("autoscaler-scalein-cooldown", 300, "time in seconds to wait after removing a worker node")
+	rootCmd.PersistentFlags().Int("autoscaler-idle-timeout", 600, "time in seconds worker node has to be idle before it is removed")
+	rootCmd.PersistentFlags().String("autoscaler-serveraddr", "", "abstruse server address passed to started worker nodes")
+	rootCmd.PersistentFlags().String("autoscaler-enrollmenttoken", "", "enrollment token passed to started worker nodes")
+	rootCmd.PersistentFlags().String("autoscaler-command-scaleout", "", "command executed to start worker node (command provider)")
+	rootCmd.PersistentFlags().String("autoscaler-command-scalein", "", "command executed to remove worker node (command provider)")
+	rootCmd.PersistentFlags().String("autoscaler-docker-image", "bleenco/abstruse-worker:latest", "worker node image (docker provider)")
+	rootCmd.PersistentFlags().String("autoscaler-docker-network", "", "network worker node containers are attached to (docker provider)")
+	rootCmd.PersistentFlags().String("autoscaler-docker-mirrordir", "", "host directory of repository mirrors of worker nodes, mirrors are disabled when empty (docker provider)")
 }
 
 func initDefaults() {
@@ -126,6 +154,8 @@ func initDefaults() {
 	viper.BindPFlag("websocket.addr", rootCmd.PersistentFlags().Lookup("websocket-addr"))
 	viper.BindPFlag("tls.cert", rootCmd.PersistentFlags().Lookup("tls-cert"))
 	viper.BindPFlag("tls.key", rootCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("tls.cacert", rootCmd.PersistentFlags().Lookup("tls-ca-cert"))
+	viper.BindPFlag("tls.cakey", rootCmd.PersistentFlags().Lookup("tls-ca-key"))
 	viper.BindPFlag("db.driver", rootCmd.PersistentFlags().Lookup("db-driver"))
 	viper.BindPFlag("db.host", rootCmd.PersistentFlags().Lookup("db-host"))
 	viper.BindPFlag("db.port", rootCmd.PersistentFlags().Lookup("db-port"))
@@ -141,6 +171,32 @@ func initDefaults() {
 	viper.BindPFlag("logger.maxage", rootCmd.PersistentFlags().Lookup("logger-max-age"))
 	viper.BindPFlag("auth.jwtsecret", rootCmd.PersistentFlags().Lookup("auth-jwtsecret"))
 	viper.BindPFlag("datadir", rootCmd.PersistentFlags().Lookup("datadir"))
+	viper.BindPFlag("plugins.allowed", rootCmd.PersistentFlags().Lookup("plugins-allowed"))
+	viper.BindPFlag("cache.quota", rootCmd.PersistentFlags().Lookup("cache-quota"))
+	viper.BindPFlag("cache.repoquota", rootCmd.PersistentFlags().Lookup("cache-repo-quota"))
+	viper.BindPFlag("storage.driver", rootCmd.PersistentFlags().Lookup("storage-driver"))
+	viper.BindPFlag("storage.presign", rootCmd.PersistentFlags().Lookup("storage-presign"))
+	viper.BindPFlag("storage.s3.endpoint", rootCmd.PersistentFlags().Lookup("storage-s3-endpoint"))
+	viper.BindPFlag("storage.s3.region", rootCmd.PersistentFlags().Lookup("storage-s3-region"))
+	viper.BindPFlag("storage.s3.bucket", rootCmd.PersistentFlags().Lookup("storage-s3-bucket"))
+	viper.BindPFlag("storage.s3.prefix", rootCmd.PersistentFlags().Lookup("storage-s3-prefix"))
+	viper.BindPFlag("storage.s3.accesskey", rootCmd.PersistentFlags().Lookup("storage-s3-accesskey"))
+	viper.BindPFlag("storage.s3.secretkey", rootCmd.PersistentFlags().Lookup("storage-s3-secretkey"))
+	viper.BindPFlag("storage.s3.pathstyle", rootCmd.PersistentFlags().Lookup("storage-s3-pathstyle"))
+	viper.BindPFlag("autoscaler.provider", rootCmd.PersistentFlags().Lookup("autoscaler-provider"))
+	viper.BindPFlag("autoscaler.min", rootCmd.PersistentFlags().Lookup("autoscaler-min"))
+	viper.BindPFlag("autoscaler.max", rootCmd.PersistentFlags().Lookup("autoscaler-max"))
+	viper.BindPFlag("autoscaler.interval", rootCmd.PersistentFlags().Lookup("autoscaler-interval"))
+	viper.BindPFlag("autoscaler.scaleoutcooldown", rootCmd.PersistentFlags().Lookup("autoscaler-scaleout-cooldown"))
+	viper.BindPFlag("autoscaler.scaleincooldown", rootCmd.PersistentFlags().Lookup("autoscaler-scalein-cooldown"))
+	viper.BindPFlag("autoscaler.idletimeout", rootCmd.PersistentFlags().Lookup("autoscaler-idle-timeout"))
+	viper.BindPFlag("autoscaler.serveraddr", rootCmd.PersistentFlags().Lookup("autoscaler-serveraddr"))
+	viper.BindPFlag("autoscaler.enrollmenttoken", rootCmd.PersistentFlags().Lookup("autoscaler-enrollmenttoken"))
+	viper.BindPFlag("autoscaler.command.scaleout", rootCmd.PersistentFlags().Lookup("autoscaler-command-scaleout"))
+	viper.BindPFlag("autoscaler.command.scalein", rootCmd.PersistentFlags().Lookup("autoscaler-command-scalein"))
+	viper.BindPFlag("autoscaler.docker.image", rootCmd.PersistentFlags().Lookup("autoscaler-docker-image"))
+	viper.BindPFlag("autoscaler.docker.network", rootCmd.PersistentFlags().Lookup("autoscaler-docker-network"))
+	viper.BindPFlag("autoscaler.docker.mirrordir", rootCmd.PersistentFlags().Lookup("autoscaler-docker-mirrordir"))
 }
 
 func newConfig() *config.Config {
@@ -228,6 +284,14 @@ func newConfig() *config.Config {
 		cfg.TLS.Key = filepath.Join(filepath.Dir(cfgFileUsed), cfg.TLS.Key)
 	}
 
+	if !strings.HasPrefix(cfg.TLS.CACert, "/") {
+		cfg.TLS.CACert = filepath.Join(filepath.Dir(cfgFileUsed), cfg.TLS.CACert)
+	}
+
+	if !strings.HasPrefix(cfg.TLS.CAKey, "/") {
+		cfg.TLS.CAKey = filepath.Join(filepath.Dir(cfgFileUsed), cfg.TLS.CAKey)
+	}
+
 	auth.Init(viper.GetString("auth.jwtsecret"))
 
 	cert, key := cfg.TLS.Cert, cfg.TLS.Key
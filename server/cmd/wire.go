@@ -4,12 +4,19 @@ package cmd
 
 import (
 	"github.com/bleenco/abstruse/server/api"
+	"github.com/bleenco/abstruse/server/blob"
 	"github.com/bleenco/abstruse/server/http"
 	"github.com/bleenco/abstruse/server/logger"
 	"github.com/bleenco/abstruse/server/scheduler"
+	"github.com/bleenco/abstruse/server/service/autoscaler"
+	cacheservice "github.com/bleenco/abstruse/server/service/cache"
+	"github.com/bleenco/abstruse/server/service/credential"
+	"github.com/bleenco/abstruse/server/service/pki"
 	"github.com/bleenco/abstruse/server/service/stats"
 	"github.com/bleenco/abstruse/server/store"
 	"github.com/bleenco/abstruse/server/store/build"
+	"github.com/bleenco/abstruse/server/store/cache"
+	"github.com/bleenco/abstruse/server/store/enrollmenttoken"
 	"github.com/bleenco/abstruse/server/store/envvariable"
 	"github.com/bleenco/abstruse/server/store/job"
 	"github.com/bleenco/abstruse/server/store/mounts"
@@ -18,6 +25,7 @@ import (
 	"github.com/bleenco/abstruse/server/store/repo"
 	"github.com/bleenco/abstruse/server/store/team"
 	"github.com/bleenco/abstruse/server/store/user"
+	"github.com/bleenco/abstruse/server/store/workeridentity"
 	"github.com/bleenco/abstruse/server/worker"
 	"github.com/bleenco/abstruse/server/ws"
 	"github.com/google/wire"
@@ -36,12 +44,20 @@ func CreateApp() (*app, error) {
 		wire.NewSet(repo.New),
 		wire.NewSet(envvariable.New),
 		wire.NewSet(mount.New),
+		wire.NewSet(enrollmenttoken.New),
+		wire.NewSet(workeridentity.New),
 		wire.NewSet(worker.NewRegistry),
 		wire.NewSet(http.New),
 		wire.NewSet(logger.New),
 		wire.NewSet(ws.New),
 		wire.NewSet(scheduler.New),
 		wire.NewSet(stats.New),
+		wire.NewSet(pki.New),
+		wire.NewSet(autoscaler.New),
+		wire.NewSet(blob.New),
+		wire.NewSet(cache.New),
+		wire.NewSet(cacheservice.New),
+		wire.NewSet(credential.New),
 		wire.NewSet(newApp, newConfig),
 	)))
 }
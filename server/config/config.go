@@ -3,13 +3,17 @@ package config
 type (
 	// Config holds configuration data,
 	Config struct {
-		DB        *DB        `json:"db"`
-		HTTP      *HTTP      `json:"http"`
-		TLS       *TLS       `json:"tls"`
-		Logger    *Logger    `json:"logger"`
-		Auth      *Auth      `json:"auth"`
-		Websocket *WebSocket `json:"websocket"`
-		DataDir   string     `json:"datadir"`
+		DB         *DB         `json:"db"`
+		HTTP       *HTTP       `json:"http"`
+		TLS        *TLS        `json:"tls"`
+		Logger     *Logger     `json:"logger"`
+		Auth       *Auth       `json:"auth"`
+		Websocket  *WebSocket  `json:"websocket"`
+		Autoscaler *Autoscaler `json:"autoscaler"`
+		Plugins    *Plugins    `json:"plugins"`
+		Storage    *Storage    `json:"storage"`
+		Cache      *Cache      `json:"cache"`
+		DataDir    string      `json:"datadir"`
 	}
 
 	// DB database config.
@@ -33,8 +37,10 @@ type (
 
 	// TLS config.
 	TLS struct {
-		Cert string `json:"cert"`
-		Key  string `json:"key"`
+		Cert   string `json:"cert"`
+		Key    string `json:"key"`
+		CACert string `json:"caCert"`
+		CAKey  string `json:"caKey"`
 	}
 
 	// Logger config.
@@ -56,4 +62,61 @@ type (
 	WebSocket struct {
 		Addr string `json:"addr"`
 	}
+
+	// Autoscaler config.
+	Autoscaler struct {
+		Provider         string             `json:"provider"`
+		Min              int                `json:"min"`
+		Max              int                `json:"max"`
+		Interval         int                `json:"interval"`
+		ScaleOutCooldown int                `json:"scaleOutCooldown"`
+		ScaleInCooldown  int                `json:"scaleInCooldown"`
+		IdleTimeout      int                `json:"idleTimeout"`
+		ServerAddr       string             `json:"serverAddr"`
+		EnrollmentToken  string             `json:"enrollmentToken"`
+		Command          *AutoscalerCommand `json:"command"`
+		Docker           *AutoscalerDocker  `json:"docker"`
+	}
+
+	// Plugins config.
+	Plugins struct {
+		Allowed []string `json:"allowed"`
+	}
+
+	// Cache config, quotas are sizes like 10GB, 0 is unlimited.
+	Cache struct {
+		Quota     string `json:"quota"`
+		RepoQuota string `json:"repoQuota"`
+	}
+
+	// Storage blob storage config.
+	Storage struct {
+		Driver  string     `json:"driver"`
+		Presign bool       `json:"presign"`
+		S3      *StorageS3 `json:"s3"`
+	}
+
+	// StorageS3 S3 compatible storage config.
+	StorageS3 struct {
+		Endpoint  string `json:"endpoint"`
+		Region    string `json:"region"`
+		Bucket    string `json:"bucket"`
+		Prefix    string `json:"prefix"`
+		AccessKey string `json:"accessKey"`
+		SecretKey string `json:"secretKey"`
+		PathStyle bool   `json:"pathStyle"`
+	}
+
+	// AutoscalerCommand command provider config.
+	AutoscalerCommand struct {
+		ScaleOut string `json:"scaleOut"`
+		ScaleIn  string `json:"scaleIn"`
+	}
+
+	// AutoscalerDocker docker provider config.
+	AutoscalerDocker struct {
+		Image     string `json:"image"`
+		Network   string `json:"network"`
+		MirrorDir string `json:"mirrorDir"`
+	}
 )
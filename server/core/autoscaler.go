@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// AutoscalerStatus defines autoscaler current state.
+	AutoscalerStatus struct {
+		Enabled      bool       `json:"enabled"`
+		Provider     string     `json:"provider"`
+		Min          int        `json:"min"`
+		Max          int        `json:"max"`
+		Managed      []string   `json:"managed"`
+		Provisioning []string   `json:"provisioning"`
+		LastScaleOut *time.Time `json:"lastScaleOut"`
+		LastScaleIn  *time.Time `json:"lastScaleIn"`
+	}
+
+	// Autoscaler adds and removes worker nodes based on scheduler load.
+	Autoscaler interface {
+		// Status returns autoscaler current state.
+		Status() AutoscalerStatus
+	}
+
+	// AutoscalerProvider starts and removes worker nodes.
+	AutoscalerProvider interface {
+		// ScaleOut starts new worker node with provided ID.
+		ScaleOut(context.Context, string) error
+
+		// ScaleIn removes worker node with provided ID.
+		ScaleIn(context.Context, string) error
+
+		// List returns IDs of worker nodes started by provider.
+		List(context.Context) ([]string, error)
+	}
+)
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrBlobNotFound is returned when blob does not exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+type (
+	// Blob represents stored blob.
+	Blob struct {
+		Key     string
+		Size    int64
+		ModTime time.Time
+	}
+
+	// BlobStore defines operations of storage of caches and artifacts.
+	// Keys are slash separated paths.
+	BlobStore interface {
+		// Stat returns blob with key.
+		Stat(context.Context, string) (*Blob, error)
+
+		// List returns blobs with key starting with prefix.
+		List(context.Context, string) ([]*Blob, error)
+
+		// Get returns reader of content of blob with key starting at
+		// offset.
+		Get(context.Context, string, int64) (io.ReadCloser, error)
+
+		// Put stores size bytes read from reader as blob with key,
+		// existing blob is replaced.
+		Put(context.Context, string, io.Reader, int64) error
+
+		// Move moves blob with key to another key, existing blob is
+		// replaced.
+		Move(context.Context, string, string) error
+
+		// Delete deletes blob with key.
+		Delete(context.Context, string) error
+	}
+
+	// BlobPresigner is implemented by blob stores which can issue
+	// URLs workers use to access blobs directly.
+	BlobPresigner interface {
+		// PresignURL returns URL which allows request with method to
+		// blob with key until it expires.
+		PresignURL(method, key string, expires time.Duration) (string, error)
+	}
+)
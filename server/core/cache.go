@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// CacheBlobPrefix is key prefix of cache archives in blob store.
+	CacheBlobPrefix = "cache/"
+
+	// CacheBlobExt is extension of cache archives.
+	CacheBlobExt = ".tgz"
+
+	// CacheChecksumExt is extension of blobs holding SHA-256 checksum
+	// of cache archives.
+	CacheChecksumExt = ".sha256"
+)
+
+type (
+	// Cache defines `caches` db table.
+	Cache struct {
+		ID           uint      `gorm:"primary_key;auto_increment;not null" json:"id"`
+		Key          string    `gorm:"column:cache_key;not null;size:255" json:"key"`
+		Size         int64     `gorm:"not null;default:0" json:"size"`
+		LastUsed     time.Time `json:"lastUsed"`
+		RepositoryID uint      `gorm:"not null;index" json:"repositoryID"`
+		Timestamp
+	}
+
+	// CacheUsage defines cache storage usage.
+	CacheUsage struct {
+		Size         int64             `json:"size"`
+		Count        int               `json:"count"`
+		Quota        int64             `json:"quota"`
+		RepoQuota    int64             `json:"repoQuota"`
+		Repositories []*CacheRepoUsage `json:"repositories"`
+	}
+
+	// CacheRepoUsage defines cache storage usage of repository.
+	CacheRepoUsage struct {
+		RepositoryID uint  `json:"repositoryID"`
+		Size         int64 `json:"size"`
+		Count        int   `json:"count"`
+	}
+
+	// CacheStore defines operations on cache index in datastore.
+	CacheStore interface {
+		// Find returns cache from datastore.
+		Find(uint) (*Cache, error)
+
+		// FindKey returns cache by key from datastore.
+		FindKey(string) (*Cache, error)
+
+		// List returns caches of repository from datastore, most
+		// recently used first.
+		List(uint) ([]*Cache, error)
+
+		// ListAll returns all caches from datastore, least recently
+		// used first.
+		ListAll() ([]*Cache, error)
+
+		// Save persists a new or updated cache to the datastore.
+		Save(*Cache) error
+
+		// Delete deletes cache from the datastore.
+		Delete(*Cache) error
+	}
+
+	// CacheService defines operations on caches and their archives in
+	// blob store.
+	CacheService interface {
+		// Add indexes stored cache archive of repository and evicts
+		// least recently used caches over quotas.
+		Add(context.Context, uint, string, int64) error
+
+		// Use updates last use of cache with key.
+		Use(string)
+
+		// Delete deletes cache and its archive.
+		Delete(context.Context, *Cache) error
+
+		// Usage returns cache storage usage.
+		Usage() (*CacheUsage, error)
+	}
+)
+
+// CacheBlobKey returns blob key of archive of cache with key. Key is
+// escaped so it is always a single path segment, keys with control
+// characters or relative path segments are rejected.
+func CacheBlobKey(key string) (string, error) {
+	name := url.QueryEscape(key) + CacheBlobExt
+	if key == "" || len(name) > 255 || strings.IndexFunc(key, unicode.IsControl) != -1 {
+		return "", fmt.Errorf("invalid cache key")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid cache key")
+		}
+	}
+	return CacheBlobPrefix + name, nil
+}
+
+// CacheKeyFromBlob returns key of cache with archive stored as blob with
+// key blobKey, it returns false for other blobs.
+func CacheKeyFromBlob(blobKey string) (string, bool) {
+	name := strings.TrimPrefix(blobKey, CacheBlobPrefix)
+	if name == blobKey || strings.Contains(name, "/") || !strings.HasSuffix(name, CacheBlobExt) {
+		return "", false
+	}
+	key, err := url.QueryUnescape(strings.TrimSuffix(name, CacheBlobExt))
+	return key, err == nil && key != ""
+}
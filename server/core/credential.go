@@ -0,0 +1,19 @@
+package core
+
+type (
+	// CloneCredentials defines credentials issued to the job for cloning
+	// its repository. URL is relative to the server address when
+	// repository is cloned through the git proxy of the server.
+	CloneCredentials struct {
+		URL      string
+		Username string
+		Password string
+	}
+
+	// CredentialService defines operations on credentials issued to jobs.
+	CredentialService interface {
+		// Clone returns read-only credentials for cloning repository of
+		// the job.
+		Clone(*Job) (*CloneCredentials, error)
+	}
+)
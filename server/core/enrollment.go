@@ -0,0 +1,100 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// Worker identity statuses.
+const (
+	WorkerStatusPending  = "pending"
+	WorkerStatusApproved = "approved"
+	WorkerStatusRevoked  = "revoked"
+)
+
+type (
+	// EnrollmentToken defines `enrollment_tokens` db table.
+	EnrollmentToken struct {
+		ID              uint       `gorm:"primary_key;auto_increment;not null" json:"id"`
+		Description     string     `json:"description"`
+		Hash            string     `gorm:"not null;size:64;unique_index" json:"-"`
+		Prefix          string     `gorm:"not null;size:8" json:"prefix"`
+		Reusable        bool       `gorm:"not null;default:false" json:"reusable"`
+		RequireApproval bool       `gorm:"not null;default:false" json:"requireApproval"`
+		Uses            int        `gorm:"not null;default:0" json:"uses"`
+		ExpiresAt       *time.Time `json:"expiresAt"`
+		UserID          uint       `json:"userID"`
+		Timestamp
+	}
+
+	// EnrollmentTokenStore defines operations on enrollment tokens in datastore.
+	EnrollmentTokenStore interface {
+		// Find returns enrollment token from datastore.
+		Find(uint) (*EnrollmentToken, error)
+
+		// FindToken returns enrollment token by plain token value.
+		FindToken(string) (*EnrollmentToken, error)
+
+		// List returns list of enrollment tokens from datastore.
+		List() ([]*EnrollmentToken, error)
+
+		// Create persists a new enrollment token to the datastore.
+		Create(*EnrollmentToken) error
+
+		// Use increments number of token uses in the datastore, it
+		// fails when one-time token is already used.
+		Use(*EnrollmentToken) error
+
+		// Delete deletes enrollment token from the datastore.
+		Delete(*EnrollmentToken) error
+	}
+
+	// WorkerIdentity defines `worker_identities` db table.
+	WorkerIdentity struct {
+		ID                uint       `gorm:"primary_key;auto_increment;not null" json:"id"`
+		WorkerID          string     `gorm:"not null;size:255;unique_index" json:"workerID"`
+		Status            string     `gorm:"not null;size:20;default:'pending'" json:"status"` // pending | approved | revoked
+		TokenID           string     `gorm:"size:64" json:"-"`
+		Secret            string     `gorm:"size:64" json:"-"`
+		Addr              string     `json:"addr"`
+		LastSeen          *time.Time `json:"lastSeen"`
+		EnrollmentTokenID uint       `json:"enrollmentTokenID"`
+		Timestamp
+	}
+
+	// WorkerIdentityStore defines operations on worker identities in datastore.
+	WorkerIdentityStore interface {
+		// Find returns worker identity by worker ID from datastore.
+		Find(string) (*WorkerIdentity, error)
+
+		// List returns list of worker identities from datastore.
+		List() ([]*WorkerIdentity, error)
+
+		// Create persists a new worker identity to the datastore.
+		Create(*WorkerIdentity) error
+
+		// Update persists updated worker identity to the datastore.
+		Update(*WorkerIdentity) error
+
+		// Delete deletes worker identity from the datastore.
+		Delete(*WorkerIdentity) error
+	}
+)
+
+// HashEnrollmentToken returns hash of plain enrollment token
+// which is stored in the datastore.
+func HashEnrollmentToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// Valid returns error if enrollment token cannot be used.
+func (t *EnrollmentToken) Valid() error {
+	if t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now()) {
+		return fmt.Errorf("enrollment token expired")
+	}
+	if !t.Reusable && t.Uses > 0 {
+		return fmt.Errorf("enrollment token already used")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package core
+
+import "crypto/tls"
+
+type (
+	// PKIService defines operations of the built-in certificate
+	// authority used for mutual TLS between server and workers.
+	PKIService interface {
+		// CACert returns PEM encoded CA certificate.
+		CACert() []byte
+
+		// SignWorkerCert signs certificate signing request for
+		// worker with specified ID and returns PEM encoded certificate.
+		SignWorkerCert(string, []byte) ([]byte, error)
+
+		// ClientTLSConfig returns TLS configuration used to connect
+		// to worker node with specified ID.
+		ClientTLSConfig(string) *tls.Config
+	}
+)
@@ -1,9 +1,13 @@
 package core
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type (
@@ -15,6 +19,7 @@ type (
 		AccessToken string     `gorm:"not null" json:"-"`
 		Secret      string     `gorm:"not null" json:"secret"`
 		Host        string     `gorm:"not null" json:"host"`
+		KnownHosts  string     `sql:"type:text" json:"knownHosts"`
 		LastSync    *time.Time `json:"lastSync"`
 		UserID      uint       `gorm:"not null" json:"userID"`
 		User        User       `json:"user"`
@@ -39,14 +44,52 @@ type (
 		// Update persists updated provider to the datastore.
 		Update(*Provider) error
 
+		// UpdateKnownHosts persists known SSH host keys of provider
+		// to the datastore.
+		UpdateKnownHosts(*Provider) error
+
 		// Delete deletes a provider from the datastore.
 		Delete(*Provider) error
 
 		// Sync synchronizes provider repositories with local repositories.
 		Sync(uint) error
 	}
+
+	// KnownHost defines SSH host key in known_hosts format.
+	KnownHost struct {
+		Hosts       []string `json:"hosts"`
+		Type        string   `json:"type"`
+		Fingerprint string   `json:"fingerprint"`
+		Line        string   `json:"line"`
+	}
 )
 
+// ParseKnownHosts parses SSH host keys in known_hosts format, empty lines
+// and comments are skipped.
+func ParseKnownHosts(data string) ([]*KnownHost, error) {
+	var hosts []*KnownHost
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		marker, patterns, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid known host on line %d: %v", n+1, err)
+		}
+		if marker != "" {
+			return nil, fmt.Errorf("invalid known host on line %d: marker %s not supported", n+1, marker)
+		}
+		hosts = append(hosts, &KnownHost{
+			Hosts:       patterns,
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Line:        knownhosts.Line(patterns, key),
+		})
+	}
+	return hosts, nil
+}
+
 // AfterDelete hook on provider which deletes all related repositories.
 func (p *Provider) AfterDelete(tx *gorm.DB) error {
 	return tx.Model(&Repository{}).
@@ -42,5 +42,8 @@ type (
 
 		// Stats returns scheduler current statistics.
 		Stats() SchedulerStats
+
+		// SetCapacity changes max parallel jobs of the worker node.
+		SetCapacity(string, int) error
 	}
 )
@@ -2,7 +2,6 @@ package core
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"sync"
@@ -10,13 +9,21 @@ import (
 
 	"github.com/bleenco/abstruse/internal/auth"
 	pb "github.com/bleenco/abstruse/pb"
-	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/pkg/lib"
 	"github.com/bleenco/abstruse/server/ws"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+// ReattachTimeout defines how long server waits for worker node to
+// become available again when stream of running job is lost.
+const ReattachTimeout = 5 * time.Minute
+
+var errStreamClosed = fmt.Errorf("job stream closed before job finished")
+
 type (
 	// WorkerRegistry represents registry of operational worker nodes.
 	WorkerRegistry interface {
@@ -30,6 +37,11 @@ type (
 		List() ([]*Worker, error)
 	}
 
+	// jobStream is a stream of job output from worker node.
+	jobStream interface {
+		Recv() (*pb.JobResp, error)
+	}
+
 	// Worker represents connected worker node.
 	Worker struct {
 		sync.Mutex
@@ -37,6 +49,8 @@ type (
 		Addr     string
 		Max      int
 		Running  int
+		Draining bool
+		Cordoned bool
 		Host     HostInfo
 		Usage    []WorkerUsage
 		Conn     *grpc.ClientConn
@@ -63,6 +77,7 @@ type (
 		VirtualizationRole   string    `json:"virtualizationRole"`
 		HostID               string    `json:"hostID"`
 		MaxParallel          uint64    `json:"maxParallel"`
+		Labels               []string  `json:"labels"`
 		ConnectedAt          time.Time `json:"connectedAt"`
 	}
 
@@ -72,27 +87,21 @@ type (
 		Mem       int       `json:"mem"`
 		Max       int       `json:"jobsMax"`
 		Running   int       `json:"jobsRunning"`
+		Freed     uint64    `json:"freed"`
 		Timestamp time.Time `json:"timestamp"`
 	}
 )
 
-// NewWorker returns new worker instance.
-func NewWorker(id, addr string, config *config.Config, registry WorkerRegistry, ws *ws.App) (*Worker, error) {
-	if config.TLS.Cert == "" || config.TLS.Key == "" {
-		return nil, fmt.Errorf("certificate and key must be specified")
-	}
-
+// NewWorker returns new worker instance. Secret is used to sign
+// requests to the worker node, when empty shared JWT secret is used.
+func NewWorker(id, addr, secret string, pki PKIService, registry WorkerRegistry, ws *ws.App) (*Worker, error) {
 	grpcOpts := []grpc.DialOption{}
-	certificate, err := tls.LoadX509KeyPair(config.TLS.Cert, config.TLS.Key)
-	if err != nil {
-		return nil, err
+	creds := credentials.NewTLS(pki.ClientTLSConfig(id))
+	key := auth.JWTSecret
+	if secret != "" {
+		key = []byte(secret)
 	}
-
-	creds := credentials.NewTLS(&tls.Config{
-		Certificates:       []tls.Certificate{certificate},
-		InsecureSkipVerify: true,
-	})
-	jwt, err := auth.GenerateWorkerJWT(id)
+	jwt, err := auth.GenerateWorkerJWT(id, key)
 	if err != nil {
 		return nil, err
 	}
@@ -145,6 +154,7 @@ func (w *Worker) Connect(ctx context.Context) error {
 		VirtualizationRole:   info.GetVirtualizationRole(),
 		HostID:               info.GetHostID(),
 		MaxParallel:          info.GetMaxParallel(),
+		Labels:               info.GetLabels(),
 		ConnectedAt:          time.Now(),
 	}
 	w.Max = int(info.GetMaxParallel())
@@ -161,22 +171,55 @@ func (w *Worker) Connect(ctx context.Context) error {
 	return nil
 }
 
-// StartJob starts the job.
+// StartJob starts the job. When stream with the worker node is lost
+// it reattaches to the job output from the last received offset.
 func (w *Worker) StartJob(ctx context.Context, job *pb.Job) (*pb.Job, error) {
+	var stream jobStream
 	stream, err := w.CLI.StartJob(ctx, job)
 	if err != nil {
 		return job, err
 	}
 
+	var offset uint64
+	lost := time.Now()
+	for {
+		received, err := w.receive(stream, job, &offset)
+		if err == nil {
+			return job, nil
+		}
+		if received {
+			lost = time.Now()
+		}
+		if stream, err = w.reattach(ctx, job.GetId(), offset, lost, err); err != nil {
+			return job, err
+		}
+	}
+}
+
+// receive reads job output from the stream until the job is done.
+// It returns true if any response has been received. Stream closed
+// before the job is done is lost, so the job is reattached.
+func (w *Worker) receive(stream jobStream, job *pb.Job, offset *uint64) (bool, error) {
+	var received, done bool
+
 	for {
 		resp, err := stream.Recv()
 		if err != nil {
 			if err == io.EOF {
-				break
+				if done {
+					return received, nil
+				}
+				return received, errStreamClosed
 			}
 
-			return job, err
+			return received, err
+		}
+
+		received = true
+		if resp.GetOffset() < *offset {
+			continue
 		}
+		*offset = resp.GetOffset() + 1
 
 		switch resp.GetType() {
 		case pb.JobResp_Log:
@@ -199,13 +242,83 @@ func (w *Worker) StartJob(ctx context.Context, job *pb.Job) (*pb.Job, error) {
 				status = "queued"
 			case pb.JobResp_StatusRunning:
 				status = "running"
+			case pb.JobResp_StatusErrored, pb.JobResp_StatusInterrupted:
+				status = "errored"
+				if resp.GetStatus() == pb.JobResp_StatusInterrupted {
+					status = "interrupted"
+				}
+				id, log := resp.GetId(), fmt.Sprintf("\r\n==> job %s (%s)\r\n", status, resp.GetReason())
+				job.Log = append(job.Log, log)
+				w.WS.Broadcast(fmt.Sprintf("/subs/logs/%d", id), map[string]interface{}{
+					"id":  id,
+					"log": log,
+				})
 			}
 			job.Status = status
-			break
+			done = true
 		}
 	}
+}
 
-	return job, nil
+// reattach waits for the worker node to become available again and
+// attaches to the job output starting at offset. It gives up when job
+// is not found on the worker node or ReattachTimeout is exceeded.
+func (w *Worker) reattach(ctx context.Context, id, offset uint64, lost time.Time, err error) (jobStream, error) {
+	for {
+		if ctx.Err() != nil || status.Code(err) == codes.NotFound || time.Since(lost) > ReattachTimeout {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+
+		var stream pb.API_AttachJobClient
+		if stream, err = w.current().CLI.AttachJob(ctx, &pb.JobAttachReq{Id: id, Offset: offset}); err == nil {
+			return stream, nil
+		}
+	}
+}
+
+// current returns worker from the registry with the same ID, which
+// is a new instance when worker node reconnected meanwhile.
+func (w *Worker) current() *Worker {
+	if workers, err := w.Registry.List(); err == nil {
+		for _, worker := range workers {
+			if worker.ID == w.ID {
+				return worker
+			}
+		}
+	}
+	return w
+}
+
+// HasLabels returns true if worker node provides all specified labels.
+func (w *Worker) HasLabels(labels []string) bool {
+	for _, label := range labels {
+		if !lib.Include(w.Host.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetCapacity changes max parallel jobs on the worker node.
+func (w *Worker) SetCapacity(ctx context.Context, max int) error {
+	resp, err := w.CLI.SetCapacity(ctx, &pb.Capacity{MaxParallel: uint64(max)})
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	w.Max = int(resp.GetMaxParallel())
+	w.Host.MaxParallel = resp.GetMaxParallel()
+	w.emitUsage()
+	w.Unlock()
+
+	return nil
 }
 
 // StopJob stops the running job.
@@ -232,11 +345,13 @@ func (w *Worker) usageStats(ctx context.Context) error {
 		}
 
 		w.Lock()
+		w.Draining = stats.GetDraining()
 		usage := WorkerUsage{
 			CPU:       int(stats.GetCpu()),
 			Mem:       int(stats.GetMem()),
 			Max:       w.Max,
 			Running:   w.Running,
+			Freed:     stats.GetFreed(),
 			Timestamp: time.Now(),
 		}
 		w.Usage = append(w.Usage, usage)
@@ -283,6 +398,8 @@ func (w *Worker) emitUsage() {
 		"mem":         usage.Mem,
 		"jobsMax":     w.Max,
 		"jobsRunning": w.Running,
+		"draining":    w.Draining,
+		"freed":       usage.Freed,
 		"timestamp":   time.Now(),
 	})
 }
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	api "github.com/bleenco/abstruse/pb"
+)
+
+// CacheConfig defines structure for cache config in .abstruse.yml file,
+// list of cached paths or caches with keys.
+type CacheConfig []CacheEntryConfig
+
+// CacheEntryConfig defines cache with key computed from the workspace
+// and restore keys used as prefix fallbacks when there is no cache with
+// the key. Path without key is cached per branch.
+type CacheEntryConfig struct {
+	Key         string   `yaml:"key"`
+	Paths       []string `yaml:"paths"`
+	RestoreKeys []string `yaml:"restore_keys"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (c *CacheEntryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		c.Paths = []string{path}
+		return nil
+	}
+	type entry CacheEntryConfig
+	return unmarshal((*entry)(c))
+}
+
+// paths returns all cached paths.
+func (c CacheConfig) paths() []string {
+	var paths []string
+	for _, entry := range c {
+		paths = append(paths, entry.Paths...)
+	}
+	return paths
+}
+
+// caches returns caches of the job in scope, paths without key are
+// merged into one cache.
+func (c CacheConfig) caches(scope string) ([]*api.Cache, error) {
+	var caches []*api.Cache
+	var paths []string
+	for _, entry := range c {
+		if len(entry.Paths) == 0 {
+			return nil, fmt.Errorf("cache %s: paths not specified", entry.Key)
+		}
+		for _, p := range entry.Paths {
+			if p == "" || strings.HasPrefix(path.Clean(strings.TrimPrefix(p, "/")), "..") {
+				return nil, fmt.Errorf("cache path %s must be inside the workspace", p)
+			}
+		}
+		if entry.Key == "" {
+			if len(entry.RestoreKeys) > 0 {
+				return nil, fmt.Errorf("cache restore_keys require key")
+			}
+			paths = append(paths, entry.Paths...)
+			continue
+		}
+		caches = append(caches, &api.Cache{
+			Key:         entry.Key,
+			Paths:       entry.Paths,
+			RestoreKeys: entry.RestoreKeys,
+			Scope:       scope,
+		})
+	}
+	if len(paths) > 0 {
+		caches = append([]*api.Cache{{Paths: paths, Scope: scope}}, caches...)
+	}
+	return caches, nil
+}
+
+// setCache sets caches of the job, it has to be called after setSpec.
+// Jobs with different image, matrix environment or runs_on labels have
+// separate caches.
+func (j *JobConfig) setCache(cache CacheConfig, image ImageConfig, env string) error {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s", image.Name, image.Dockerfile, image.Context, env, strings.Join(j.RunsOn, ","))
+	caches, err := cache.caches(hex.EncodeToString(h.Sum(nil))[:12])
+	if err != nil {
+		return err
+	}
+	j.Cache = cache.paths()
+	j.Commands.Caches = caches
+	return nil
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	api "github.com/bleenco/abstruse/pb"
+)
+
+// DockerConfig defines structure for docker image build config in .abstruse.yml file.
+type DockerConfig struct {
+	Dockerfile string            `yaml:"dockerfile"`
+	Context    string            `yaml:"context"`
+	Tags       []string          `yaml:"tags"`
+	BuildArgs  map[string]string `yaml:"build_args"`
+	Push       PushConfig        `yaml:"push"`
+}
+
+// PushConfig defines when built docker image is pushed to the registry.
+type PushConfig struct {
+	Branches StringSlice `yaml:"branches"`
+	Tags     bool        `yaml:"tags"`
+}
+
+// generateDockerJob returns job which builds docker image after all
+// test jobs passed. Image is pushed on matching branches and tags, but
+// never from pull requests. Environment variables in tags and build
+// arguments are expanded.
+func (c *ConfigParser) generateDockerJob() (*JobConfig, error) {
+	cfg := c.Parsed.Docker
+	if len(cfg.Tags) == 0 {
+		return nil, fmt.Errorf("docker tags not specified")
+	}
+
+	env := make(map[string]string)
+	for _, e := range c.Env {
+		if i := strings.Index(e, "="); i > 0 {
+			env[e[:i]] = e[i+1:]
+		}
+	}
+	expand := func(s string) string {
+		return os.Expand(s, func(key string) string { return env[key] })
+	}
+
+	var tags []string
+	for _, tag := range cfg.Tags {
+		tags = append(tags, expand(tag))
+	}
+	var args []string
+	for key, val := range cfg.BuildArgs {
+		args = append(args, fmt.Sprintf("%s=%s", key, expand(val)))
+	}
+	sort.Strings(args)
+
+	push := false
+	if env["ABSTRUSE_PULL_REQUEST"] == "false" {
+		if tag := env["ABSTRUSE_TAG"]; tag != "" && tag != "false" {
+			push = cfg.Push.Tags
+		} else if len(cfg.Push.Branches) > 0 {
+			match, err := matchBranch(cfg.Push.Branches, c.Branch)
+			if err != nil {
+				return nil, fmt.Errorf("docker push: %v", err)
+			}
+			push = match
+		}
+	}
+
+	step := &api.Step{
+		Name: "docker",
+		Type: api.Command_Script,
+		When: []string{StepStatusSuccess},
+		Docker: &api.DockerBuild{
+			Dockerfile: cfg.Dockerfile,
+			Context:    cfg.Context,
+			Tags:       tags,
+			BuildArgs:  args,
+			Push:       push,
+		},
+	}
+
+	return &JobConfig{
+		Env:      c.Env,
+		Mount:    strings.Join(c.Mount, ","),
+		Stage:    JobStageDeploy,
+		Title:    "docker build " + strings.Join(tags, " "),
+		Commands: &api.CommandList{Steps: []*api.Step{step}},
+		RunsOn:   c.Parsed.RunsOn,
+	}, nil
+}
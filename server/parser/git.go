@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"fmt"
+
+	api "github.com/bleenco/abstruse/pb"
+)
+
+// defaultGitDepth is clone depth of repositories without git config.
+const defaultGitDepth = 50
+
+// GitConfig defines how the repository is cloned in .abstruse.yml file.
+// Depth 0 clones full history, submodules are cloned unless disabled.
+type GitConfig struct {
+	Depth      *int  `yaml:"depth"`
+	Submodules *bool `yaml:"submodules"`
+	LFS        bool  `yaml:"lfs"`
+}
+
+// git returns git options of jobs, nil when git config is not set.
+func (g *GitConfig) git() (*api.Git, error) {
+	if g == nil {
+		return nil, nil
+	}
+	git := &api.Git{Depth: defaultGitDepth, Submodules: true, Lfs: g.LFS}
+	if g.Depth != nil {
+		if *g.Depth < 0 {
+			return nil, fmt.Errorf("invalid git depth %d", *g.Depth)
+		}
+		git.Depth = int32(*g.Depth)
+	}
+	if g.Submodules != nil {
+		git.Submodules = *g.Submodules
+	}
+	return git, nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	api "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/lib"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -17,26 +18,55 @@ const (
 
 // RepoConfig defines structure for .abstruse.yml configuration files.
 type RepoConfig struct {
-	Image         string         `yaml:"image"`
-	Branches      BranchesConfig `yaml:"branches"`
-	Matrix        []MatrixConfig `yaml:"matrix"`
-	BeforeInstall []string       `yaml:"before_install"`
-	Install       []string       `yaml:"install"`
-	BeforeScript  []string       `yaml:"before_script"`
-	Script        []string       `yaml:"script"`
-	AfterSuccess  []string       `yaml:"after_success"`
-	AfterFailure  []string       `yaml:"after_failure"`
-	BeforeDeploy  []string       `yaml:"before_deploy"`
-	Deploy        []string       `yaml:"deploy"`
-	AfterDeploy   []string       `yaml:"after_deploy"`
-	AfterScript   []string       `yaml:"after_script"`
-	Cache         []string       `yaml:"cache"`
+	Version       int             `yaml:"version"`
+	Image         ImageConfig     `yaml:"image"`
+	Branches      BranchesConfig  `yaml:"branches"`
+	Matrix        []MatrixConfig  `yaml:"matrix"`
+	BeforeInstall []string        `yaml:"before_install"`
+	Install       []string        `yaml:"install"`
+	BeforeScript  []string        `yaml:"before_script"`
+	Script        []string        `yaml:"script"`
+	AfterSuccess  []string        `yaml:"after_success"`
+	AfterFailure  []string        `yaml:"after_failure"`
+	BeforeDeploy  []string        `yaml:"before_deploy"`
+	Deploy        []string        `yaml:"deploy"`
+	AfterDeploy   []string        `yaml:"after_deploy"`
+	AfterScript   []string        `yaml:"after_script"`
+	Cache         CacheConfig     `yaml:"cache"`
+	RunsOn        []string        `yaml:"runs_on"`
+	Steps         []StepConfig    `yaml:"steps"`
+	Docker        *DockerConfig   `yaml:"docker"`
+	Resources     ResourcesConfig `yaml:"resources"`
+	Git           *GitConfig      `yaml:"git"`
 }
 
 // MatrixConfig defines structure for matrix job config in .abstruse.yml file.
 type MatrixConfig struct {
-	Env   string `yaml:"env"`
-	Image string `yaml:"image"`
+	Env       string          `yaml:"env"`
+	Image     ImageConfig     `yaml:"image"`
+	RunsOn    []string        `yaml:"runs_on"`
+	Resources ResourcesConfig `yaml:"resources"`
+}
+
+// ImageConfig defines image of the job in .abstruse.yml file, either
+// name of the image or Dockerfile in the repository image is built from.
+type ImageConfig struct {
+	Name       string `yaml:"-"`
+	Dockerfile string `yaml:"dockerfile"`
+	Context    string `yaml:"context"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (i *ImageConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&i.Name); err == nil {
+		return nil
+	}
+	type image ImageConfig
+	return unmarshal((*image)(i))
+}
+
+func (i ImageConfig) empty() bool {
+	return i.Name == "" && i.Dockerfile == ""
 }
 
 // BranchesConfig defines structure for branches config in .abstruse.yml file.
@@ -54,15 +84,54 @@ type JobConfig struct {
 	Title    string           `json:"title"`
 	Commands *api.CommandList `json:"commands"`
 	Cache    []string         `json:"cache"`
+	RunsOn   []string         `json:"runsOn"`
+}
+
+// ResourcesConfig defines resource limits of the job container in
+// .abstruse.yml file.
+type ResourcesConfig struct {
+	CPUs   float64 `yaml:"cpus"`
+	Memory string  `yaml:"memory"`
+	Pids   int64   `yaml:"pids"`
+}
+
+// setSpec sets image and resources of the job. Image built from
+// Dockerfile and resources are passed to the worker with commands, path
+// of the Dockerfile is used as image.
+func (j *JobConfig) setSpec(image ImageConfig, resources ResourcesConfig) error {
+	commands := &api.CommandList{
+		Commands: j.Commands.GetCommands(),
+		Steps:    j.Commands.GetSteps(),
+	}
+	j.Commands = commands
+
+	j.Image = image.Name
+	if image.Dockerfile != "" {
+		j.Image = image.Dockerfile
+		commands.Image = &api.DockerBuild{Dockerfile: image.Dockerfile, Context: image.Context}
+	}
+
+	memory, err := lib.ParseMemory(resources.Memory)
+	if err != nil {
+		return err
+	}
+	if resources.CPUs < 0 || resources.Pids < 0 {
+		return fmt.Errorf("invalid resources")
+	}
+	if resources.CPUs > 0 || memory > 0 || resources.Pids > 0 {
+		commands.Resources = &api.Resources{Cpus: resources.CPUs, Memory: memory, Pids: resources.Pids}
+	}
+	return nil
 }
 
 // ConfigParser defines repository configuration parser.
 type ConfigParser struct {
-	Raw    string
-	Branch string
-	Parsed RepoConfig
-	Env    []string
-	Mount  []string
+	Raw     string
+	Branch  string
+	Parsed  RepoConfig
+	Env     []string
+	Mount   []string
+	Plugins []string
 }
 
 // NewConfigParser returns new config parser instance.
@@ -87,8 +156,14 @@ func (c *ConfigParser) Parse() ([]*JobConfig, error) {
 		return jobs, err
 	}
 
-	if len(c.Parsed.Script) == 0 {
-		return jobs, fmt.Errorf("script commands not specified")
+	git, err := c.Parsed.Git.git()
+	if err != nil {
+		return jobs, err
+	}
+
+	commands, title, err := c.generateJobCommands()
+	if err != nil {
+		return jobs, err
 	}
 
 	if len(c.Parsed.Matrix) > 0 {
@@ -96,13 +171,26 @@ func (c *ConfigParser) Parse() ([]*JobConfig, error) {
 			job := &JobConfig{}
 
 			// set image
-			if item.Image != "" {
-				job.Image = item.Image
+			job.Commands = commands
+			image, resources := c.Parsed.Image, c.Parsed.Resources
+			if !item.Image.empty() {
+				image = item.Image
+			}
+			if item.Resources != (ResourcesConfig{}) {
+				resources = item.Resources
+			}
+			if err := job.setSpec(image, resources); err != nil {
+				return jobs, err
+			}
+
+			// set labels of workers job runs on
+			if len(item.RunsOn) > 0 {
+				job.RunsOn = item.RunsOn
 			} else {
-				job.Image = c.Parsed.Image
+				job.RunsOn = c.Parsed.RunsOn
 			}
 
-			if job.Image == "" {
+			if job.Image == "" && len(job.RunsOn) == 0 {
 				return jobs, fmt.Errorf("image not specified")
 			}
 
@@ -119,24 +207,30 @@ func (c *ConfigParser) Parse() ([]*JobConfig, error) {
 			if item.Env != "" {
 				job.Title = item.Env
 			} else {
-				job.Title = strings.Join(c.Parsed.Script, " ")
+				job.Title = title
+			}
+			if err := job.setCache(c.Parsed.Cache, image, item.Env); err != nil {
+				return jobs, err
 			}
-			job.Commands = c.generateCommands()
-			job.Cache = c.Parsed.Cache
 
 			jobs = append(jobs, job)
 		}
 	} else {
 		job := &JobConfig{
-			Image:    c.Parsed.Image,
 			Env:      c.Env,
 			Mount:    strings.Join(c.Mount, ","),
 			Stage:    JobStageTest,
-			Title:    strings.Join(c.Parsed.Script, " "),
-			Commands: c.generateCommands(),
-			Cache:    c.Parsed.Cache,
+			Title:    title,
+			Commands: commands,
+			RunsOn:   c.Parsed.RunsOn,
+		}
+		if err := job.setSpec(c.Parsed.Image, c.Parsed.Resources); err != nil {
+			return jobs, err
+		}
+		if err := job.setCache(c.Parsed.Cache, c.Parsed.Image, ""); err != nil {
+			return jobs, err
 		}
-		if job.Image == "" {
+		if job.Image == "" && len(job.RunsOn) == 0 {
 			return jobs, fmt.Errorf("image not specified")
 		}
 
@@ -145,21 +239,38 @@ func (c *ConfigParser) Parse() ([]*JobConfig, error) {
 
 	if len(c.Parsed.Deploy) > 0 {
 		job := &JobConfig{
-			Image:    c.Parsed.Image,
 			Env:      c.Env,
 			Mount:    strings.Join(c.Mount, ","),
 			Stage:    JobStageDeploy,
 			Title:    strings.Join(c.Parsed.Deploy, " "),
 			Commands: c.generateDeployCommands(),
-			Cache:    c.Parsed.Cache,
+			RunsOn:   c.Parsed.RunsOn,
 		}
-		if job.Image == "" {
+		if err := job.setSpec(c.Parsed.Image, c.Parsed.Resources); err != nil {
+			return jobs, err
+		}
+		if err := job.setCache(c.Parsed.Cache, c.Parsed.Image, ""); err != nil {
+			return jobs, err
+		}
+		if job.Image == "" && len(job.RunsOn) == 0 {
 			return jobs, fmt.Errorf("image not specified")
 		}
 
 		jobs = append(jobs, job)
 	}
 
+	if c.Parsed.Docker != nil {
+		job, err := c.generateDockerJob()
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, job := range jobs {
+		job.Commands.Git = git
+	}
+
 	return jobs, nil
 }
 
@@ -197,6 +308,55 @@ func (c *ConfigParser) ShouldBuild() bool {
 	return true
 }
 
+// generateJobCommands returns commands and title of test jobs. Version
+// 1 config is converted to steps, so all jobs run steps.
+func (c *ConfigParser) generateJobCommands() (*api.CommandList, string, error) {
+	switch c.Parsed.Version {
+	case 0, 1:
+		if len(c.Parsed.Steps) > 0 {
+			return nil, "", fmt.Errorf("steps require version 2 config")
+		}
+		if len(c.Parsed.Script) == 0 {
+			return nil, "", fmt.Errorf("script commands not specified")
+		}
+		commands := c.generateCommands()
+		commands.Steps = StepsFromCommands(commands.Commands)
+		return commands, strings.Join(c.Parsed.Script, " "), nil
+	case 2:
+		if c.hasPhases() {
+			return nil, "", fmt.Errorf("version 2 config must use steps instead of phases")
+		}
+		if len(c.Parsed.Steps) == 0 {
+			return nil, "", fmt.Errorf("steps not specified")
+		}
+		steps, err := c.generateSteps()
+		if err != nil {
+			return nil, "", err
+		}
+		var names []string
+		for _, step := range steps {
+			names = append(names, step.GetName())
+		}
+		return &api.CommandList{Steps: steps}, strings.Join(names, ", "), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported config version %d", c.Parsed.Version)
+	}
+}
+
+// hasPhases checks if any of version 1 phases is specified.
+func (c *ConfigParser) hasPhases() bool {
+	p := c.Parsed
+	for _, cmds := range [][]string{
+		p.BeforeInstall, p.Install, p.BeforeScript, p.Script, p.AfterSuccess, p.AfterFailure,
+		p.BeforeDeploy, p.Deploy, p.AfterDeploy, p.AfterScript,
+	} {
+		if len(cmds) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *ConfigParser) generateCommands() *api.CommandList {
 	var commands api.CommandList
 
@@ -217,6 +377,7 @@ func (c *ConfigParser) generateDeployCommands() *api.CommandList {
 	commands.Commands = append(commands.Commands, c.appendCommands(c.Parsed.BeforeDeploy, api.Command_BeforeDeploy)...)
 	commands.Commands = append(commands.Commands, c.appendCommands(c.Parsed.Deploy, api.Command_Deploy)...)
 	commands.Commands = append(commands.Commands, c.appendCommands(c.Parsed.AfterDeploy, api.Command_AfterDeploy)...)
+	commands.Steps = StepsFromCommands(commands.Commands)
 
 	return &commands
 }
@@ -0,0 +1,252 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	api "github.com/bleenco/abstruse/pb"
+)
+
+// Step statuses used in when conditions.
+const (
+	StepStatusSuccess = "success"
+	StepStatusFailure = "failure"
+)
+
+// StepConfig defines structure for step config in version 2 .abstruse.yml file.
+type StepConfig struct {
+	Name            string                 `yaml:"name"`
+	Commands        []string               `yaml:"commands"`
+	Image           string                 `yaml:"image"`
+	Uses            string                 `yaml:"uses"`
+	With            map[string]interface{} `yaml:"with"`
+	Env             map[string]string      `yaml:"env"`
+	WorkingDir      string                 `yaml:"working_dir"`
+	Shell           string                 `yaml:"shell"`
+	When            WhenConfig             `yaml:"when"`
+	ContinueOnError bool                   `yaml:"continue_on_error"`
+}
+
+// WhenConfig defines conditions under which the step runs.
+type WhenConfig struct {
+	Status []string    `yaml:"status"`
+	Branch StringSlice `yaml:"branch"`
+}
+
+// StringSlice is a list of strings which can also be specified as a
+// single string in .abstruse.yml file.
+type StringSlice []string
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (s *StringSlice) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		*s = []string{str}
+		return nil
+	}
+	var slice []string
+	if err := unmarshal(&slice); err != nil {
+		return err
+	}
+	*s = slice
+	return nil
+}
+
+// generateSteps returns steps of version 2 config which run on the
+// branch, steps with branch condition not matching are left out.
+func (c *ConfigParser) generateSteps() ([]*api.Step, error) {
+	var steps []*api.Step
+	for i, s := range c.Parsed.Steps {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+		if s.Uses != "" {
+			if len(s.Commands) > 0 || s.Image != "" || s.Shell != "" || s.WorkingDir != "" {
+				return nil, fmt.Errorf("step %s: plugin step cannot specify commands, image, shell or working_dir", name)
+			}
+			if !c.pluginAllowed(s.Uses) {
+				return nil, fmt.Errorf("step %s: plugin %s is not allowed", name, s.Uses)
+			}
+		} else if len(s.Commands) == 0 {
+			return nil, fmt.Errorf("step %s: commands not specified", name)
+		}
+		for _, status := range s.When.Status {
+			if status != StepStatusSuccess && status != StepStatusFailure {
+				return nil, fmt.Errorf("step %s: unknown status %s in when condition", name, status)
+			}
+		}
+		if s.Shell != "" && s.Shell != "sh" && s.Shell != "bash" {
+			return nil, fmt.Errorf("step %s: unsupported shell %s", name, s.Shell)
+		}
+		if strings.HasPrefix(s.WorkingDir, "/") || strings.Contains(s.WorkingDir, "..") {
+			return nil, fmt.Errorf("step %s: working_dir must be relative to the workspace", name)
+		}
+
+		match, err := matchBranch(s.When.Branch, c.Branch)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %v", name, err)
+		}
+		if !match {
+			continue
+		}
+
+		when := s.When.Status
+		if len(when) == 0 {
+			when = []string{StepStatusSuccess}
+		}
+		env := toSlice(s.Env)
+		settings, err := pluginEnv(s.With)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %v", name, err)
+		}
+		env = append(env, settings...)
+		sort.Strings(env)
+
+		steps = append(steps, &api.Step{
+			Name:            name,
+			Type:            api.Command_Script,
+			Commands:        s.Commands,
+			Image:           s.Image,
+			Uses:            s.Uses,
+			Env:             env,
+			WorkingDir:      s.WorkingDir,
+			Shell:           s.Shell,
+			When:            when,
+			ContinueOnError: s.ContinueOnError,
+		})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no steps to run on branch %s", c.Branch)
+	}
+	return steps, nil
+}
+
+// pluginAllowed checks if plugin image matches any of the allowed image
+// patterns, all images are allowed when no patterns are configured.
+func (c *ConfigParser) pluginAllowed(image string) bool {
+	if len(c.Plugins) == 0 {
+		return true
+	}
+	for _, pattern := range c.Plugins {
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginEnv converts plugin settings into PLUGIN_* environment variables
+// following the Drone plugin convention. Lists are joined with comma and
+// maps are encoded as JSON.
+func pluginEnv(with map[string]interface{}) ([]string, error) {
+	var env []string
+	for key, val := range with {
+		var value string
+		switch v := val.(type) {
+		case nil:
+		case string:
+			value = v
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			value = strings.Join(items, ",")
+		case map[interface{}]interface{}:
+			data, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				return nil, err
+			}
+			value = string(data)
+		default:
+			value = fmt.Sprint(v)
+		}
+		name := strings.Trim(envUnsafeChars.ReplaceAllString(strings.ToUpper(key), "_"), "_")
+		env = append(env, fmt.Sprintf("PLUGIN_%s=%s", name, value))
+	}
+	return env, nil
+}
+
+var envUnsafeChars = regexp.MustCompile("[^A-Z0-9]+")
+
+// jsonValue converts maps decoded from yaml into maps JSON can encode.
+func jsonValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// matchBranch checks if branch matches any of the patterns, empty
+// patterns match all branches.
+func matchBranch(patterns []string, branch string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, pattern := range patterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid branch pattern %s: %v", pattern, err)
+		}
+		if r.MatchString(branch) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StepsFromCommands converts commands of version 1 config into steps,
+// consecutive commands of the same type form one step. Conditions of
+// the steps follow the phase semantics, after_success, after_failure,
+// after_deploy and after_script do not affect the job status.
+func StepsFromCommands(commands []*api.Command) []*api.Step {
+	var steps []*api.Step
+	for _, command := range commands {
+		if n := len(steps); n > 0 && steps[n-1].GetType() == command.GetType() {
+			steps[n-1].Commands = append(steps[n-1].Commands, command.GetCommand())
+			continue
+		}
+
+		step := &api.Step{
+			Name:     phaseName(command.GetType()),
+			Type:     command.GetType(),
+			Commands: []string{command.GetCommand()},
+			When:     []string{StepStatusSuccess},
+		}
+		switch command.GetType() {
+		case api.Command_AfterSuccess, api.Command_AfterDeploy:
+			step.ContinueOnError = true
+		case api.Command_AfterFailure:
+			step.When = []string{StepStatusFailure}
+			step.ContinueOnError = true
+		case api.Command_AfterScript:
+			step.When = []string{StepStatusSuccess, StepStatusFailure}
+			step.ContinueOnError = true
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+var camelCase = regexp.MustCompile("([a-z])([A-Z])")
+
+// phaseName returns name of the phase as used in config, e.g. before_install.
+func phaseName(typ api.Command_CommandType) string {
+	return strings.ToLower(camelCase.ReplaceAllString(typ.String(), "${1}_${2}"))
+}
@@ -8,10 +8,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bleenco/abstruse/internal/auth"
 	pb "github.com/bleenco/abstruse/pb"
 	"github.com/bleenco/abstruse/pkg/gitscm"
 	"github.com/bleenco/abstruse/pkg/lib"
 	"github.com/bleenco/abstruse/server/core"
+	"github.com/bleenco/abstruse/server/parser"
 	"github.com/bleenco/abstruse/server/ws"
 	"github.com/drone/go-scm/scm"
 	"github.com/logrusorgru/aurora"
@@ -24,37 +26,42 @@ func New(
 	workers core.WorkerRegistry,
 	jobStore core.JobStore,
 	buildStore core.BuildStore,
+	credentials core.CredentialService,
 	logger *zap.Logger,
 	ws *ws.Server,
 ) core.Scheduler {
 	s := &scheduler{
-		ready:      make(chan struct{}, 1),
-		interval:   time.Minute,
-		workers:    workers,
-		jobStore:   jobStore,
-		buildStore: buildStore,
-		logger:     logger.With(zap.String("type", "scheduler")).Sugar(),
-		pending:    make(map[uint]*jobType),
-		ws:         ws,
-		ctx:        context.Background(),
+		ready:       make(chan struct{}, 1),
+		interval:    time.Minute,
+		workers:     workers,
+		jobStore:    jobStore,
+		buildStore:  buildStore,
+		credentials: credentials,
+		logger:      logger.With(zap.String("type", "scheduler")).Sugar(),
+		pending:     make(map[uint]*jobType),
+		waiting:     make(map[uint]bool),
+		ws:          ws,
+		ctx:         context.Background(),
 	}
 	go s.run()
 	return s
 }
 
 type scheduler struct {
-	mu         sync.Mutex
-	ready      chan struct{}
-	paused     bool
-	interval   time.Duration
-	workers    core.WorkerRegistry
-	jobStore   core.JobStore
-	buildStore core.BuildStore
-	logger     *zap.SugaredLogger
-	queued     []*core.Job
-	pending    map[uint]*jobType
-	ws         *ws.Server
-	ctx        context.Context
+	mu          sync.Mutex
+	ready       chan struct{}
+	paused      bool
+	interval    time.Duration
+	workers     core.WorkerRegistry
+	jobStore    core.JobStore
+	buildStore  core.BuildStore
+	credentials core.CredentialService
+	logger      *zap.SugaredLogger
+	queued      []*core.Job
+	pending     map[uint]*jobType
+	waiting     map[uint]bool
+	ws          *ws.Server
+	ctx         context.Context
 }
 
 type jobType struct {
@@ -66,9 +73,12 @@ type jobType struct {
 
 func (s *scheduler) Next(job *core.Job) error {
 	s.logger.Infof("scheduling job %d from build %d...", job.ID, job.BuildID)
-	s.Stop(job.ID)
+	s.stop(job.ID)
 	s.mu.Lock()
 	s.queued = append(s.queued, job)
+	if job.Stage == parser.JobStageDeploy {
+		s.waiting[job.BuildID] = true
+	}
 	s.mu.Unlock()
 
 	job.Status = "queued"
@@ -90,12 +100,25 @@ func (s *scheduler) Next(job *core.Job) error {
 		}
 	}(job)
 
+	if job.Stage == parser.JobStageDeploy {
+		s.checkDeploy(job.BuildID)
+	}
 	s.next(s.ctx)
 
 	return nil
 }
 
 func (s *scheduler) Stop(id uint) (bool, error) {
+	job, stopped, err := s.stop(id)
+	if job != nil && job.Stage != parser.JobStageDeploy {
+		s.checkDeploy(job.BuildID)
+	}
+	return stopped, err
+}
+
+// stop removes job from the queue or stops it on the worker and
+// returns it.
+func (s *scheduler) stop(id uint) (*core.Job, bool, error) {
 	if job, err := s.findJob(id); err == nil {
 		s.removeJob(id)
 		job.Status = "failing"
@@ -103,10 +126,10 @@ func (s *scheduler) Stop(id uint) (bool, error) {
 		job.Log = red(fmt.Sprintf("%s\r\n", "==> job stopped"))
 		s.logger.Infof("job %d removed from queue", id)
 		if err := s.saveJob(job); err == nil {
-			return true, nil
+			return job, true, nil
 		}
 		s.logger.Errorf("error saving job %d: %v", job.ID, err.Error())
-		return false, nil
+		return job, false, nil
 	}
 
 	if job, ok := s.pending[id]; ok {
@@ -125,7 +148,7 @@ func (s *scheduler) Stop(id uint) (bool, error) {
 			if err := s.saveJob(job.job); err != nil {
 				s.logger.Errorf("error saving job %d: %v", job.job.ID, err.Error())
 			}
-			return false, err
+			return job.job, false, err
 		}
 
 		stopped, _ := worker.StopJob(job.pb)
@@ -137,10 +160,10 @@ func (s *scheduler) Stop(id uint) (bool, error) {
 			s.logger.Errorf("error saving job %d: %v", job.job.ID, err.Error())
 		}
 
-		return stopped, nil
+		return job.job, stopped, nil
 	}
 
-	return false, nil
+	return nil, false, nil
 }
 
 func (s *scheduler) RestartBuild(id uint) error {
@@ -238,6 +261,19 @@ func (s *scheduler) Stats() core.SchedulerStats {
 	}
 }
 
+func (s *scheduler) SetCapacity(id string, max int) error {
+	worker, err := s.getWorker(id)
+	if err != nil {
+		return err
+	}
+	if err := worker.SetCapacity(s.ctx, max); err != nil {
+		return err
+	}
+	s.logger.Infof("worker %s capacity set to %d", id, max)
+	s.next(s.ctx)
+	return nil
+}
+
 func (s *scheduler) process() error {
 	s.mu.Lock()
 	paused := s.paused
@@ -247,15 +283,11 @@ func (s *scheduler) process() error {
 		return fmt.Errorf("scheduler paused")
 	}
 
-	worker, err := s.findWorker()
+	job, worker, err := s.enqueueJob()
 	if err != nil {
 		return err
 	}
-	if worker == nil {
-		return nil
-	}
-	job, err := s.enqueueJob()
-	if err != nil || job == nil {
+	if job == nil {
 		return nil
 	}
 
@@ -311,35 +343,65 @@ func (s *scheduler) startJob(job *core.Job, worker *core.Worker) {
 	if err := protojson.Unmarshal([]byte(job.Commands), &commands); err != nil {
 		s.logger.Errorf("error parsing commands for job %d: %s", job.ID, err.Error())
 	}
-
-	j := &pb.Job{
-		Id:            uint64(job.ID),
-		BuildId:       uint64(job.BuildID),
-		Commands:      commands.Commands,
-		Image:         job.Image,
-		Env:           envs,
-		Url:           job.Build.Repository.URL,
-		SshURL:        job.Build.Repository.CloneSSH,
-		ProviderName:  job.Build.Repository.Provider.Name,
-		ProviderURL:   job.Build.Repository.Provider.URL,
-		ProviderToken: job.Build.Repository.Provider.AccessToken,
-		Ref:           job.Build.Ref,
-		CommitSHA:     job.Build.Commit,
-		Branch:        job.Build.Branch,
-		RepoName:      job.Build.Repository.FullName,
-		Action:        pb.Job_JobStart,
-		WorkerId:      worker.ID,
-		Cache:         strings.Split(job.Cache, ","),
-		Mount:         strings.Split(job.Mount, ","),
-		SshPrivateKey: job.Build.Repository.SSHPrivateKey,
-		SshClone:      job.Build.Repository.UseSSH,
+	if len(commands.Steps) == 0 {
+		// job created before steps were introduced.
+		commands.Steps = parser.StepsFromCommands(commands.Commands)
+	}
+	if len(commands.Caches) == 0 && job.Cache != "" {
+		// job created before cache keys were introduced.
+		commands.Caches = []*pb.Cache{{Paths: strings.Split(job.Cache, ",")}}
 	}
 
-	s.mu.Lock()
 	timeout := job.Build.Repository.Timeout
 	if timeout == 0 {
 		timeout = 3600
 	}
+	// job token is valid for the job timeout and is accepted only while
+	// the job is running.
+	token, err := auth.JWT.CreateJobJWT(auth.JobClaims{JobID: job.ID, RepoID: job.Build.RepositoryID}, time.Duration(timeout)*time.Second+time.Minute)
+	if err != nil {
+		s.logger.Errorf("error creating token for job %d: %v", job.ID, err.Error())
+	}
+	var credentials *pb.Credentials
+	clone, err := s.credentials.Clone(job)
+	if err != nil {
+		s.logger.Errorf("error creating clone credentials for job %d: %v", job.ID, err.Error())
+	}
+	if clone != nil {
+		credentials = &pb.Credentials{Url: clone.URL, Username: clone.Username, Password: clone.Password}
+	}
+
+	j := &pb.Job{
+		Id:           uint64(job.ID),
+		BuildId:      uint64(job.BuildID),
+		Commands:     commands.Commands,
+		Steps:        commands.Steps,
+		ImageBuild:   commands.Image,
+		Resources:    commands.Resources,
+		Caches:       commands.Caches,
+		Image:        job.Image,
+		Env:          envs,
+		Url:          job.Build.Repository.URL,
+		SshURL:       job.Build.Repository.CloneSSH,
+		ProviderName: job.Build.Repository.Provider.Name,
+		ProviderURL:  job.Build.Repository.Provider.URL,
+		Ref:          job.Build.Ref,
+		CommitSHA:    job.Build.Commit,
+		Branch:       job.Build.Branch,
+		PullRequest:  job.Build.PR != 0,
+		RepoName:     job.Build.Repository.FullName,
+		Action:       pb.Job_JobStart,
+		WorkerId:     worker.ID,
+		Cache:        strings.Split(job.Cache, ","),
+		Mount:        strings.Split(job.Mount, ","),
+		SshClone:     job.Build.Repository.UseSSH,
+		RunsOn:       runsOn(job),
+		JobToken:     token,
+		Credentials:  credentials,
+		Git:          commands.Git,
+	}
+
+	s.mu.Lock()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	s.pending[job.ID] = &jobType{job: job, pb: j, ctx: ctx, cancel: cancel}
 	s.mu.Unlock()
@@ -356,7 +418,17 @@ func (s *scheduler) startJob(job *core.Job, worker *core.Worker) {
 
 	s.next(s.ctx)
 
-	j, err := worker.StartJob(ctx, j)
+	j, err = worker.StartJob(ctx, j)
+	if err == nil && j.GetStatus() == "interrupted" {
+		// job did not finish because of the worker, not the build itself.
+		s.logger.Infof("job %d interrupted on worker %s, rescheduling", job.ID, worker.ID)
+		s.mu.Lock()
+		delete(s.pending, job.ID)
+		s.mu.Unlock()
+		cancel()
+		s.Next(job)
+		return
+	}
 	if err != nil {
 		s.logger.Errorf("job %d errored: %v", job.ID, err.Error())
 		job.Log = strings.Join(j.GetLog(), "")
@@ -388,6 +460,9 @@ func (s *scheduler) startJob(job *core.Job, worker *core.Worker) {
 	delete(s.pending, job.ID)
 	s.mu.Unlock()
 
+	if job.Stage != parser.JobStageDeploy {
+		s.checkDeploy(job.BuildID)
+	}
 	s.next(s.ctx)
 }
 
@@ -398,16 +473,111 @@ func (s *scheduler) next(ctx context.Context) {
 	}
 }
 
-func (s *scheduler) enqueueJob() (*core.Job, error) {
+// checkDeploy releases deploy jobs of the build waiting in the queue
+// when all test jobs passed. When any of the test jobs did not pass,
+// deploy jobs are removed from the queue and marked as failing.
+func (s *scheduler) checkDeploy(buildID uint) {
+	s.mu.Lock()
+	waiting := s.waiting[buildID]
+	s.mu.Unlock()
+	if !waiting {
+		return
+	}
+
+	done, passed := s.testsDone(buildID)
+	if !done {
+		return
+	}
+
+	var skipped []*core.Job
+	s.mu.Lock()
+	delete(s.waiting, buildID)
+	if !passed {
+		var queued []*core.Job
+		for _, job := range s.queued {
+			if job.BuildID == buildID && job.Stage == parser.JobStageDeploy {
+				skipped = append(skipped, job)
+			} else {
+				queued = append(queued, job)
+			}
+		}
+		s.queued = queued
+	}
+	s.mu.Unlock()
+
+	for _, job := range skipped {
+		s.logger.Infof("job %d skipped, test jobs of build %d did not pass", job.ID, buildID)
+		job.Status = "failing"
+		job.EndTime = lib.TimeNow()
+		job.Log = red(fmt.Sprintf("%s\r\n", "==> deploy skipped, test jobs did not pass"))
+		if err := s.saveJob(job); err != nil {
+			s.logger.Errorf("error saving job %d: %v", job.ID, err.Error())
+		}
+	}
+	s.next(s.ctx)
+}
+
+// testsDone returns whether all test jobs of the build are done and
+// whether all of them passed.
+func (s *scheduler) testsDone(buildID uint) (done, passed bool) {
+	s.mu.Lock()
+	for _, job := range s.queued {
+		if job.BuildID == buildID && job.Stage != parser.JobStageDeploy {
+			s.mu.Unlock()
+			return false, false
+		}
+	}
+	for _, job := range s.pending {
+		if job.job.BuildID == buildID && job.job.Stage != parser.JobStageDeploy {
+			s.mu.Unlock()
+			return false, false
+		}
+	}
+	s.mu.Unlock()
+
+	build, err := s.buildStore.Find(buildID)
+	if err != nil {
+		s.logger.Errorf("error finding build %d: %v", buildID, err.Error())
+		return false, false
+	}
+	passed = true
+	for _, job := range build.Jobs {
+		if job.Stage == parser.JobStageDeploy {
+			continue
+		}
+		if job.EndTime == nil {
+			return false, false
+		}
+		if job.Status != "passing" {
+			passed = false
+		}
+	}
+	return true, passed
+}
+
+// enqueueJob removes first queued job that can run on one of the
+// available workers from the queue and returns it with the worker.
+// Jobs whose runs_on labels no worker provides stay queued, as well as
+// deploy jobs waiting for test jobs of their build.
+func (s *scheduler) enqueueJob() (*core.Job, *core.Worker, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.queued) > 0 {
-		job := s.queued[0]
-		s.queued = s.queued[1:]
-		return job, nil
+	workers, err := s.workers.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, job := range s.queued {
+		if job.Stage == parser.JobStageDeploy && s.waiting[job.BuildID] {
+			continue
+		}
+		if worker := findWorker(workers, runsOn(job)); worker != nil {
+			s.queued = append(s.queued[:i], s.queued[i+1:]...)
+			return job, worker, nil
+		}
 	}
-	return nil, fmt.Errorf("no jobs queued")
+	return nil, nil, nil
 }
 
 func (s *scheduler) findJob(id uint) (*core.Job, error) {
@@ -435,27 +605,21 @@ func (s *scheduler) removeJob(id uint) {
 	}
 }
 
-func (s *scheduler) findWorker() (*core.Worker, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	workers, err := s.workers.List()
-	if err != nil {
-		return nil, err
-	}
-
+// findWorker returns worker with the most free capacity which
+// provides all specified labels.
+func findWorker(workers []*core.Worker, labels []string) *core.Worker {
 	var worker *core.Worker
 	var c int
 	for _, w := range workers {
 		w.Lock()
 		diff := w.Max - w.Running
-		if diff > c {
+		if diff > c && !w.Draining && !w.Cordoned && w.HasLabels(labels) {
 			worker, c = w, diff
 		}
 		w.Unlock()
 	}
 
-	return worker, nil
+	return worker
 }
 
 func (s *scheduler) getWorker(id string) (*core.Worker, error) {
@@ -599,6 +763,11 @@ func (s *scheduler) run() error {
 	}
 }
 
+// runsOn returns runs_on labels of the job.
+func runsOn(job *core.Job) []string {
+	return lib.DeleteEmpty(strings.Split(job.RunsOn, ","))
+}
+
 func red(str string) string {
 	return aurora.Bold(aurora.Red(str)).String()
 }
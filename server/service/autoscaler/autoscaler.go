@@ -0,0 +1,305 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+	"go.uber.org/zap"
+)
+
+const (
+	// ProvisionTimeout defines how long autoscaler waits for started
+	// worker node to connect before it is considered failed.
+	ProvisionTimeout = 5 * time.Minute
+
+	// drainPeriod defines how long worker node is cordoned and
+	// checked to stay idle before it is removed.
+	drainPeriod = 10 * time.Second
+)
+
+// New returns new Autoscaler instance.
+func New(
+	config *config.Config,
+	workers core.WorkerRegistry,
+	scheduler core.Scheduler,
+	logger *zap.Logger,
+) (core.Autoscaler, error) {
+	a := &autoscaler{
+		config:       config.Autoscaler,
+		workers:      workers,
+		scheduler:    scheduler,
+		logger:       logger.With(zap.String("type", "autoscaler")).Sugar(),
+		managed:      make(map[string]bool),
+		provisioning: make(map[string]time.Time),
+		idle:         make(map[string]time.Time),
+	}
+
+	switch a.config.Provider {
+	case "":
+		return a, nil
+	case "command":
+		a.provider = newCommandProvider(a.config, filepath.Join(config.DataDir, "autoscaler.json"))
+	case "docker":
+		provider, err := newDockerProvider(a.config)
+		if err != nil {
+			return nil, err
+		}
+		a.provider = provider
+	default:
+		return nil, fmt.Errorf("unknown autoscaler provider: %s", a.config.Provider)
+	}
+
+	go a.run()
+	return a, nil
+}
+
+type autoscaler struct {
+	mu           sync.Mutex
+	config       *config.Autoscaler
+	provider     core.AutoscalerProvider
+	workers      core.WorkerRegistry
+	scheduler    core.Scheduler
+	logger       *zap.SugaredLogger
+	managed      map[string]bool
+	provisioning map[string]time.Time
+	idle         map[string]time.Time
+	lastScaleOut time.Time
+	lastScaleIn  time.Time
+}
+
+func (a *autoscaler) Status() core.AutoscalerStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := core.AutoscalerStatus{
+		Enabled:      a.provider != nil,
+		Provider:     a.config.Provider,
+		Min:          a.config.Min,
+		Max:          a.config.Max,
+		Managed:      []string{},
+		Provisioning: []string{},
+	}
+	for id := range a.managed {
+		status.Managed = append(status.Managed, id)
+	}
+	for id := range a.provisioning {
+		status.Provisioning = append(status.Provisioning, id)
+	}
+	sort.Strings(status.Managed)
+	sort.Strings(status.Provisioning)
+	if !a.lastScaleOut.IsZero() {
+		status.LastScaleOut = &a.lastScaleOut
+	}
+	if !a.lastScaleIn.IsZero() {
+		status.LastScaleIn = &a.lastScaleIn
+	}
+
+	return status
+}
+
+func (a *autoscaler) run() {
+	a.logger.Infof("starting autoscaler with %s provider", a.config.Provider)
+
+	// worker nodes started before server restart are still managed.
+	if ids, err := a.provider.List(context.Background()); err == nil {
+		a.mu.Lock()
+		for _, id := range ids {
+			a.managed[id] = true
+		}
+		a.mu.Unlock()
+	} else {
+		a.logger.Errorf("error listing worker nodes: %v", err)
+	}
+
+	interval := time.Duration(a.config.Interval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		a.check()
+	}
+}
+
+// check compares scheduler load with available capacity and adds
+// or removes worker nodes.
+func (a *autoscaler) check() {
+	stats := a.scheduler.Stats()
+	workers, err := a.workers.List()
+	if err != nil {
+		a.logger.Errorf("error listing workers: %v", err)
+		return
+	}
+	connected := make(map[string]*core.Worker)
+	for _, w := range workers {
+		connected[w.ID] = w
+	}
+
+	a.mu.Lock()
+	now := time.Now()
+	for id, started := range a.provisioning {
+		if _, ok := connected[id]; ok {
+			delete(a.provisioning, id)
+			a.managed[id] = true
+			continue
+		}
+		if now.Sub(started) > ProvisionTimeout {
+			a.logger.Errorf("worker %s did not connect in %s, removing", id, ProvisionTimeout)
+			delete(a.provisioning, id)
+			go a.remove(id)
+		}
+	}
+	for id := range a.managed {
+		w, ok := connected[id]
+		if !ok {
+			delete(a.idle, id)
+			continue
+		}
+		w.Lock()
+		running := w.Running
+		w.Unlock()
+		if running > 0 {
+			delete(a.idle, id)
+		} else if _, ok := a.idle[id]; !ok {
+			a.idle[id] = now
+		}
+	}
+	total := len(workers) + len(a.provisioning)
+	n := a.desired(stats, total)
+	candidate := a.idleCandidate(stats, total, connected)
+	a.mu.Unlock()
+
+	if n > 0 {
+		a.scaleOut(n)
+	} else if candidate != nil {
+		a.scaleIn(candidate)
+	}
+}
+
+// desired returns number of worker nodes which should be added.
+func (a *autoscaler) desired(stats core.SchedulerStats, total int) int {
+	if time.Since(a.lastScaleOut) < time.Duration(a.config.ScaleOutCooldown)*time.Second {
+		return 0
+	}
+
+	capacity := 1
+	if stats.Workers > 0 && stats.Max > stats.Workers {
+		capacity = (stats.Max + stats.Workers - 1) / stats.Workers
+	}
+
+	var n int
+	if total < a.config.Min {
+		n = a.config.Min - total
+	}
+	free := stats.Max - stats.Running + len(a.provisioning)*capacity
+	if stats.Queued > free {
+		if need := (stats.Queued - free + capacity - 1) / capacity; need > n {
+			n = need
+		}
+	}
+	if total+n > a.config.Max {
+		n = a.config.Max - total
+	}
+
+	return n
+}
+
+// idleCandidate returns managed worker node which has been idle for
+// longer than idle timeout and can be removed.
+func (a *autoscaler) idleCandidate(stats core.SchedulerStats, total int, connected map[string]*core.Worker) *core.Worker {
+	cooldown := time.Duration(a.config.ScaleInCooldown) * time.Second
+	if stats.Queued > 0 || total <= a.config.Min || time.Since(a.lastScaleIn) < cooldown || time.Since(a.lastScaleOut) < cooldown {
+		return nil
+	}
+
+	var candidate *core.Worker
+	var since time.Time
+	for id, t := range a.idle {
+		if time.Since(t) < time.Duration(a.config.IdleTimeout)*time.Second {
+			continue
+		}
+		if candidate == nil || t.Before(since) {
+			candidate, since = connected[id], t
+		}
+	}
+
+	return candidate
+}
+
+func (a *autoscaler) scaleOut(n int) {
+	a.logger.Infof("adding %d worker nodes", n)
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("autoscaler-%s", lib.RandomString())
+		if err := a.provider.ScaleOut(context.Background(), id); err != nil {
+			a.logger.Errorf("error starting worker node %s: %v", id, err)
+			continue
+		}
+
+		a.mu.Lock()
+		a.provisioning[id] = time.Now()
+		a.lastScaleOut = time.Now()
+		a.mu.Unlock()
+		a.logger.Infof("worker node %s started", id)
+	}
+}
+
+// scaleIn cordons idle worker node so it does not receive new jobs,
+// makes sure it stays idle and removes it.
+func (a *autoscaler) scaleIn(w *core.Worker) {
+	w.Lock()
+	if w.Running > 0 || w.Draining {
+		w.Unlock()
+		return
+	}
+	w.Cordoned = true
+	w.Unlock()
+
+	time.Sleep(drainPeriod)
+
+	w.Lock()
+	running := w.Running
+	if running > 0 {
+		w.Cordoned = false
+	}
+	w.Unlock()
+	if running > 0 {
+		a.mu.Lock()
+		delete(a.idle, w.ID)
+		a.mu.Unlock()
+		return
+	}
+
+	a.logger.Infof("removing idle worker node %s", w.ID)
+	if err := a.remove(w.ID); err != nil {
+		w.Lock()
+		w.Cordoned = false
+		w.Unlock()
+		return
+	}
+
+	a.mu.Lock()
+	a.lastScaleIn = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *autoscaler) remove(id string) error {
+	if err := a.provider.ScaleIn(context.Background(), id); err != nil {
+		a.logger.Errorf("error removing worker node %s: %v", id, err)
+		return err
+	}
+
+	a.mu.Lock()
+	delete(a.managed, id)
+	delete(a.idle, id)
+	a.mu.Unlock()
+	a.logger.Infof("worker node %s removed", id)
+	return nil
+}
@@ -0,0 +1,114 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// commandProvider runs configured commands to start and remove
+// worker nodes. Worker ID, server address and enrollment token are
+// passed to commands as environment variables. IDs of started worker
+// nodes are saved to file at path, so they are still managed after
+// server restart.
+type commandProvider struct {
+	mu     sync.Mutex
+	config *config.Autoscaler
+	path   string
+}
+
+func newCommandProvider(config *config.Autoscaler, path string) core.AutoscalerProvider {
+	return &commandProvider{config: config, path: path}
+}
+
+// ScaleOut saves ID of worker node before the command is executed, so
+// worker node is not lost when server stops meanwhile.
+func (p *commandProvider) ScaleOut(ctx context.Context, id string) error {
+	if err := p.update(func(ids []string) []string { return append(ids, id) }); err != nil {
+		return err
+	}
+	if err := p.exec(ctx, p.config.Command.ScaleOut, id); err != nil {
+		p.update(func(ids []string) []string { return without(ids, id) })
+		return err
+	}
+	return nil
+}
+
+func (p *commandProvider) ScaleIn(ctx context.Context, id string) error {
+	if err := p.exec(ctx, p.config.Command.ScaleIn, id); err != nil {
+		return err
+	}
+	return p.update(func(ids []string) []string { return without(ids, id) })
+}
+
+func (p *commandProvider) List(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.read()
+}
+
+// update replaces saved IDs of worker nodes with IDs returned by fn.
+func (p *commandProvider) update(fn func([]string) []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids, err := p.read()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fn(ids))
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+func (p *commandProvider) read() ([]string, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	return ids, json.Unmarshal(data, &ids)
+}
+
+func (p *commandProvider) exec(ctx context.Context, command, id string) error {
+	if command == "" {
+		return fmt.Errorf("autoscaler command not configured")
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("ABSTRUSE_WORKER_ID=%s", id),
+		fmt.Sprintf("ABSTRUSE_SERVER_ADDR=%s", p.config.ServerAddr),
+		fmt.Sprintf("ABSTRUSE_AUTH_ENROLLMENTTOKEN=%s", p.config.EnrollmentToken),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+func without(ids []string, id string) []string {
+	var rest []string
+	for _, i := range ids {
+		if i != id {
+			rest = append(rest, i)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,111 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	dockerLabel       = "abstruse.autoscaler"
+	dockerStopTimeout = 60 * time.Second
+)
+
+// dockerProvider starts worker nodes as containers on the local
+// Docker host.
+type dockerProvider struct {
+	config *config.Autoscaler
+	cli    *client.Client
+}
+
+func newDockerProvider(config *config.Autoscaler) (core.AutoscalerProvider, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerProvider{config, cli}, nil
+}
+
+func (p *dockerProvider) ScaleOut(ctx context.Context, id string) error {
+	image := p.config.Docker.Image
+	if _, _, err := p.cli.ImageInspectWithRaw(ctx, image); err != nil {
+		out, err := p.cli.ImagePull(ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err := ioutil.ReadAll(out); err != nil {
+			return err
+		}
+	}
+
+	env := []string{
+		fmt.Sprintf("ABSTRUSE_ID=%s", id),
+		fmt.Sprintf("ABSTRUSE_SERVER_ADDR=%s", p.config.ServerAddr),
+		fmt.Sprintf("ABSTRUSE_AUTH_ENROLLMENTTOKEN=%s", p.config.EnrollmentToken),
+	}
+	// job workspaces and repository mirrors are mounted into job
+	// containers from the host, so they are kept at the same path
+	// in the worker container.
+	binds := []string{"/var/run/docker.sock:/var/run/docker.sock", "/tmp:/tmp"}
+	if dir := p.config.Docker.MirrorDir; dir != "" {
+		env = append(env, fmt.Sprintf("ABSTRUSE_GIT_MIRRORDIR=%s", dir))
+		if !strings.HasPrefix(dir, "/tmp/") {
+			binds = append(binds, fmt.Sprintf("%s:%s", dir, dir))
+		}
+	}
+
+	resp, err := p.cli.ContainerCreate(ctx, &container.Config{
+		Image:  image,
+		Env:    env,
+		Labels: map[string]string{dockerLabel: id},
+	}, &container.HostConfig{
+		Binds:       binds,
+		NetworkMode: container.NetworkMode(p.config.Docker.Network),
+	}, nil, nil, id)
+	if err != nil {
+		return err
+	}
+
+	return p.cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+}
+
+// ScaleIn stops worker container giving worker time to shut down
+// gracefully and removes it.
+func (p *dockerProvider) ScaleIn(ctx context.Context, id string) error {
+	timeout := dockerStopTimeout
+	if err := p.cli.ContainerStop(ctx, id, &timeout); err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	if err := p.cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (p *dockerProvider) List(ctx context.Context) ([]string, error) {
+	containers, err := p.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", dockerLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, c := range containers {
+		if id, ok := c.Labels[dockerLabel]; ok {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+// New returns new CacheService instance. Index is reconciled with cache
+// archives in blob store in the background, archives stored before
+// caches were namespaced by repository are not assigned to any.
+func New(
+	config *config.Config,
+	caches core.CacheStore,
+	blobs core.BlobStore,
+	logger *zap.Logger,
+) (core.CacheService, error) {
+	s := &service{
+		caches: caches,
+		blobs:  blobs,
+		logger: logger.With(zap.String("type", "cache")).Sugar(),
+	}
+	if config.Cache != nil {
+		var err error
+		if s.quota, err = parseQuota(config.Cache.Quota); err != nil {
+			return nil, fmt.Errorf("invalid cache quota: %v", err)
+		}
+		if s.repoQuota, err = parseQuota(config.Cache.RepoQuota); err != nil {
+			return nil, fmt.Errorf("invalid cache repository quota: %v", err)
+		}
+	}
+
+	go s.sync()
+	return s, nil
+}
+
+type service struct {
+	mu        sync.Mutex
+	caches    core.CacheStore
+	blobs     core.BlobStore
+	quota     int64
+	repoQuota int64
+	logger    *zap.SugaredLogger
+}
+
+func (s *service) Add(ctx context.Context, repoID uint, key string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cache, err := s.caches.FindKey(key)
+	if err != nil {
+		cache = &core.Cache{Key: key}
+	}
+	cache.RepositoryID, cache.Size, cache.LastUsed = repoID, size, time.Now()
+	if err := s.caches.Save(cache); err != nil {
+		return err
+	}
+	return s.evict(ctx)
+}
+
+func (s *service) Use(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cache, err := s.caches.FindKey(key)
+	if err != nil {
+		return
+	}
+	cache.LastUsed = time.Now()
+	if err := s.caches.Save(cache); err != nil {
+		s.logger.Errorf("error updating cache %s: %v", key, err)
+	}
+}
+
+func (s *service) Delete(ctx context.Context, cache *core.Cache) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delete(ctx, cache)
+}
+
+func (s *service) Usage() (*core.CacheUsage, error) {
+	list, err := s.caches.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &core.CacheUsage{Quota: s.quota, RepoQuota: s.repoQuota}
+	repos := make(map[uint]*core.CacheRepoUsage)
+	for _, cache := range list {
+		usage.Size += cache.Size
+		usage.Count++
+		repo, ok := repos[cache.RepositoryID]
+		if !ok {
+			repo = &core.CacheRepoUsage{RepositoryID: cache.RepositoryID}
+			repos[cache.RepositoryID] = repo
+			usage.Repositories = append(usage.Repositories, repo)
+		}
+		repo.Size += cache.Size
+		repo.Count++
+	}
+	sort.Slice(usage.Repositories, func(i, j int) bool {
+		return usage.Repositories[i].Size > usage.Repositories[j].Size
+	})
+	return usage, nil
+}
+
+// evict deletes least recently used caches of repositories over the
+// repository quota and then least recently used caches until total size
+// is within the quota.
+func (s *service) evict(ctx context.Context) error {
+	list, err := s.caches.ListAll()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	repos := make(map[uint]int64)
+	for _, cache := range list {
+		total += cache.Size
+		repos[cache.RepositoryID] += cache.Size
+	}
+	for _, cache := range list {
+		overRepo := s.repoQuota > 0 && cache.RepositoryID != 0 && repos[cache.RepositoryID] > s.repoQuota
+		overTotal := s.quota > 0 && total > s.quota
+		if !overRepo && !overTotal {
+			continue
+		}
+		if err := s.delete(ctx, cache); err != nil {
+			return err
+		}
+		s.logger.Infof("evicted cache %s (%s)", cache.Key, humanize.Bytes(uint64(cache.Size)))
+		total -= cache.Size
+		repos[cache.RepositoryID] -= cache.Size
+	}
+	return nil
+}
+
+// delete deletes archive of the cache and removes it from the index.
+func (s *service) delete(ctx context.Context, cache *core.Cache) error {
+	blobKey, err := core.CacheBlobKey(cache.Key)
+	if err == nil {
+		if err := s.blobs.Delete(ctx, blobKey); err != nil && err != core.ErrBlobNotFound {
+			return err
+		}
+		if err := s.blobs.Delete(ctx, blobKey+core.CacheChecksumExt); err != nil && err != core.ErrBlobNotFound {
+			return err
+		}
+	}
+	return s.caches.Delete(cache)
+}
+
+// sync indexes cache archives missing in the index, removes caches
+// without archive from the index and evicts caches over quotas.
+func (s *service) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := context.Background()
+	list, err := s.blobs.List(ctx, core.CacheBlobPrefix)
+	if err != nil {
+		s.logger.Errorf("error listing caches: %v", err)
+		return
+	}
+	blobs := make(map[string]*core.Blob)
+	for _, blob := range list {
+		if key, ok := core.CacheKeyFromBlob(blob.Key); ok {
+			blobs[key] = blob
+		}
+	}
+
+	caches, err := s.caches.ListAll()
+	if err != nil {
+		s.logger.Errorf("error listing caches: %v", err)
+		return
+	}
+	for _, cache := range caches {
+		if _, ok := blobs[cache.Key]; ok {
+			delete(blobs, cache.Key)
+			continue
+		}
+		if err := s.caches.Delete(cache); err != nil {
+			s.logger.Errorf("error removing cache %s: %v", cache.Key, err)
+		}
+	}
+	for key, blob := range blobs {
+		cache := &core.Cache{Key: key, Size: blob.Size, LastUsed: blob.ModTime}
+		if i := strings.Index(key, "/"); i > 0 {
+			// keys are namespaced by repository ID.
+			if id, err := strconv.ParseUint(key[:i], 10, 64); err == nil {
+				cache.RepositoryID = uint(id)
+			}
+		}
+		if err := s.caches.Save(cache); err != nil {
+			s.logger.Errorf("error indexing cache %s: %v", key, err)
+		}
+	}
+	if len(blobs) > 0 {
+		s.logger.Infof("indexed %d caches", len(blobs))
+	}
+
+	if err := s.evict(ctx); err != nil {
+		s.logger.Errorf("error evicting caches: %v", err)
+	}
+}
+
+// parseQuota parses size like 10GB, empty size or 0 is unlimited.
+func parseQuota(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	n, err := humanize.ParseBytes(size)
+	return int64(n), err
+}
@@ -0,0 +1,44 @@
+package credential
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bleenco/abstruse/internal/auth"
+	"github.com/bleenco/abstruse/server/core"
+)
+
+// cloneExpiration is validity of clone credentials, repository is
+// cloned when job starts.
+const cloneExpiration = 15 * time.Minute
+
+// New returns new CredentialService instance.
+func New() core.CredentialService {
+	return credentialService{}
+}
+
+// credentialService issues clone credentials for git proxy of the server.
+// Providers are configured with personal access tokens which can't be
+// restricted to a single repository and SSH private keys of repositories
+// don't expire, so neither leaves the server and jobs get tokens accepted
+// only for their own repository.
+type credentialService struct{}
+
+// Clone returns read-only credentials for cloning repository of the job.
+func (s credentialService) Clone(job *core.Job) (*core.CloneCredentials, error) {
+	if job.Build == nil || job.Build.Repository == nil {
+		return nil, fmt.Errorf("repository of job %d not found", job.ID)
+	}
+	repo := job.Build.Repository
+
+	token, err := auth.JWT.CreateCloneJWT(auth.CloneClaims{JobID: job.ID, RepoID: repo.ID}, cloneExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &core.CloneCredentials{
+		URL:      fmt.Sprintf("api/v1/workers/git/%s.git", repo.FullName),
+		Username: "abstruse",
+		Password: token,
+	}, nil
+}
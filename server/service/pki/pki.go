@@ -0,0 +1,93 @@
+package pki
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/tlsutil"
+	"github.com/bleenco/abstruse/server/config"
+	"github.com/bleenco/abstruse/server/core"
+	"go.uber.org/zap"
+)
+
+const (
+	// WorkerCertValidity defines how long signed worker certificates are valid.
+	WorkerCertValidity = 30 * 24 * time.Hour
+
+	// ClientCertValidity defines how long server client certificate is valid.
+	ClientCertValidity = 7 * 24 * time.Hour
+
+	clientCertName = "abstruse-server"
+)
+
+// New returns new PKIService instance.
+func New(config *config.Config, logger *zap.Logger) (core.PKIService, error) {
+	ca, err := tlsutil.LoadOrGenerateCA(config.TLS.CACert, config.TLS.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.IssueClientCert(clientCertName, ClientCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	keypair, err := tlsutil.NewKeypair(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pkiService{
+		ca:      ca,
+		keypair: keypair,
+		logger:  logger.With(zap.String("type", "pki")).Sugar(),
+	}
+	go s.run()
+	return s, nil
+}
+
+type pkiService struct {
+	ca      *tlsutil.CA
+	keypair *tlsutil.Keypair
+	logger  *zap.SugaredLogger
+}
+
+func (s *pkiService) CACert() []byte {
+	return s.ca.CertPEM()
+}
+
+func (s *pkiService) SignWorkerCert(id string, csr []byte) ([]byte, error) {
+	cert, err := s.ca.SignCSR(csr, id, WorkerCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Infof("issued certificate for worker %s", id)
+	return cert, nil
+}
+
+func (s *pkiService) ClientTLSConfig(id string) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: s.keypair.GetClientCertificate,
+		RootCAs:              s.ca.Pool(),
+		ServerName:           id,
+		MinVersion:           tls.VersionTLS12,
+	}
+}
+
+// run rotates server client certificate before it expires.
+func (s *pkiService) run() {
+	ticker := time.NewTicker(time.Hour)
+	for range ticker.C {
+		if !s.keypair.NeedsRenewal() {
+			continue
+		}
+		cert, err := s.ca.IssueClientCert(clientCertName, ClientCertValidity)
+		if err != nil {
+			s.logger.Errorf("error renewing client certificate: %v", err)
+			continue
+		}
+		if err := s.keypair.Set(cert); err != nil {
+			s.logger.Errorf("error renewing client certificate: %v", err)
+			continue
+		}
+		s.logger.Infof("client certificate renewed, valid until %s", cert.Leaf.NotAfter)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/bleenco/abstruse/pkg/gitscm"
 	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/server/config"
 	"github.com/bleenco/abstruse/server/core"
 	"github.com/bleenco/abstruse/server/parser"
 	"github.com/jinzhu/gorm"
@@ -14,14 +15,15 @@ import (
 )
 
 // New returns a new BuildStore
-func New(db *gorm.DB, repos core.RepositoryStore, jobs core.JobStore) core.BuildStore {
-	return buildStore{db, repos, jobs}
+func New(db *gorm.DB, repos core.RepositoryStore, jobs core.JobStore, config *config.Config) core.BuildStore {
+	return buildStore{db, repos, jobs, config}
 }
 
 type buildStore struct {
-	db    *gorm.DB
-	repos core.RepositoryStore
-	jobs  core.JobStore
+	db     *gorm.DB
+	repos  core.RepositoryStore
+	jobs   core.JobStore
+	config *config.Config
 }
 
 func (s buildStore) Find(id uint) (*core.Build, error) {
@@ -61,7 +63,7 @@ func (s buildStore) FindStatus(token, branch string) (string, error) {
 		if job.Status == "running" {
 			running = true
 		}
-		if job.Status == "failing" {
+		if job.Status == "failing" || job.Status == "errored" {
 			failing = true
 		}
 	}
@@ -178,6 +180,9 @@ func (s buildStore) GenerateBuild(repo *core.Repository, base *core.GitHook) ([]
 	}
 
 	parser := parser.NewConfigParser(string(content.Data), base.Target, parser.GenerateGlobalEnv(build), mnts)
+	if s.config.Plugins != nil {
+		parser.Plugins = s.config.Plugins.Allowed
+	}
 	pjobs, err := parser.Parse()
 	if err != nil {
 		return nil, 0, err
@@ -206,6 +211,7 @@ func (s buildStore) GenerateBuild(repo *core.Repository, base *core.GitHook) ([]
 			BuildID:  build.ID,
 			Mount:    strings.Join(mnts, ","),
 			Cache:    strings.Join(j.Cache, ","),
+			RunsOn:   strings.Join(j.RunsOn, ","),
 		}
 		if err := s.jobs.Create(job); err != nil {
 			return nil, 0, err
@@ -301,6 +307,9 @@ func (s buildStore) TriggerBuild(opts core.TriggerBuildOpts) ([]*core.Job, error
 	}
 
 	parser := parser.NewConfigParser(content, branch, parser.GenerateGlobalEnv(build), mnts)
+	if s.config.Plugins != nil {
+		parser.Plugins = s.config.Plugins.Allowed
+	}
 	pjobs, err := parser.Parse()
 	if err != nil {
 		return nil, err
@@ -332,6 +341,7 @@ func (s buildStore) TriggerBuild(opts core.TriggerBuildOpts) ([]*core.Job, error
 			Stage:    j.Stage,
 			BuildID:  build.ID,
 			Cache:    strings.Join(j.Cache, ","),
+			RunsOn:   strings.Join(j.RunsOn, ","),
 		}
 		if err := s.jobs.Create(job); err != nil {
 			return nil, err
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/jinzhu/gorm"
+)
+
+// New returns a new CacheStore.
+func New(db *gorm.DB) core.CacheStore {
+	return cacheStore{db}
+}
+
+type cacheStore struct {
+	db *gorm.DB
+}
+
+func (s cacheStore) Find(id uint) (*core.Cache, error) {
+	cache := &core.Cache{}
+	err := s.db.Where("id = ?", id).First(&cache).Error
+	return cache, err
+}
+
+func (s cacheStore) FindKey(key string) (*core.Cache, error) {
+	cache := &core.Cache{}
+	err := s.db.Where("cache_key = ?", key).First(&cache).Error
+	return cache, err
+}
+
+func (s cacheStore) List(repoID uint) ([]*core.Cache, error) {
+	var caches []*core.Cache
+	err := s.db.Where("repository_id = ?", repoID).Order("last_used desc").Find(&caches).Error
+	return caches, err
+}
+
+func (s cacheStore) ListAll() ([]*core.Cache, error) {
+	var caches []*core.Cache
+	err := s.db.Order("last_used asc").Find(&caches).Error
+	return caches, err
+}
+
+func (s cacheStore) Save(cache *core.Cache) error {
+	return s.db.Save(&cache).Error
+}
+
+func (s cacheStore) Delete(cache *core.Cache) error {
+	return s.db.Unscoped().Delete(&cache).Error
+}
@@ -0,0 +1,57 @@
+package enrollmenttoken
+
+import (
+	"fmt"
+
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/jinzhu/gorm"
+)
+
+// New returns a new EnrollmentTokenStore.
+func New(db *gorm.DB) core.EnrollmentTokenStore {
+	return enrollmentTokenStore{db}
+}
+
+type enrollmentTokenStore struct {
+	db *gorm.DB
+}
+
+func (s enrollmentTokenStore) Find(id uint) (*core.EnrollmentToken, error) {
+	token := &core.EnrollmentToken{}
+	err := s.db.Where("id = ?", id).First(&token).Error
+	return token, err
+}
+
+func (s enrollmentTokenStore) FindToken(token string) (*core.EnrollmentToken, error) {
+	t := &core.EnrollmentToken{}
+	err := s.db.Where("hash = ?", core.HashEnrollmentToken(token)).First(&t).Error
+	return t, err
+}
+
+func (s enrollmentTokenStore) List() ([]*core.EnrollmentToken, error) {
+	var tokens []*core.EnrollmentToken
+	err := s.db.Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s enrollmentTokenStore) Create(token *core.EnrollmentToken) error {
+	return s.db.Create(&token).Error
+}
+
+func (s enrollmentTokenStore) Use(token *core.EnrollmentToken) error {
+	db := s.db.Model(&core.EnrollmentToken{}).
+		Where("id = ? AND (reusable = ? OR uses = 0)", token.ID, true).
+		UpdateColumn("uses", gorm.Expr("uses + ?", 1))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected != 1 {
+		return fmt.Errorf("enrollment token already used")
+	}
+	token.Uses++
+	return nil
+}
+
+func (s enrollmentTokenStore) Delete(token *core.EnrollmentToken) error {
+	return s.db.Delete(&token).Error
+}
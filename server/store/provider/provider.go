@@ -47,6 +47,10 @@ func (s providerStore) Update(provider *core.Provider) error {
 	return s.db.Model(provider).Updates(&provider).Error
 }
 
+func (s providerStore) UpdateKnownHosts(provider *core.Provider) error {
+	return s.db.Model(provider).Update("known_hosts", provider.KnownHosts).Error
+}
+
 func (s providerStore) Delete(provider *core.Provider) error {
 	return s.db.Delete(&provider).Error
 }
@@ -62,6 +62,9 @@ func connect(cfg *config.DB, logger *zap.Logger) {
 				core.Provider{},
 				core.Job{},
 				core.Build{},
+				core.EnrollmentToken{},
+				core.WorkerIdentity{},
+				core.Cache{},
 			)
 			db = conn
 			log.Debugf("succesfully connected to database")
@@ -0,0 +1,39 @@
+package workeridentity
+
+import (
+	"github.com/bleenco/abstruse/server/core"
+	"github.com/jinzhu/gorm"
+)
+
+// New returns a new WorkerIdentityStore.
+func New(db *gorm.DB) core.WorkerIdentityStore {
+	return workerIdentityStore{db}
+}
+
+type workerIdentityStore struct {
+	db *gorm.DB
+}
+
+func (s workerIdentityStore) Find(id string) (*core.WorkerIdentity, error) {
+	identity := &core.WorkerIdentity{}
+	err := s.db.Where("worker_id = ?", id).First(&identity).Error
+	return identity, err
+}
+
+func (s workerIdentityStore) List() ([]*core.WorkerIdentity, error) {
+	var identities []*core.WorkerIdentity
+	err := s.db.Order("created_at desc").Find(&identities).Error
+	return identities, err
+}
+
+func (s workerIdentityStore) Create(identity *core.WorkerIdentity) error {
+	return s.db.Create(&identity).Error
+}
+
+func (s workerIdentityStore) Update(identity *core.WorkerIdentity) error {
+	return s.db.Save(identity).Error
+}
+
+func (s workerIdentityStore) Delete(identity *core.WorkerIdentity) error {
+	return s.db.Unscoped().Delete(&identity).Error
+}
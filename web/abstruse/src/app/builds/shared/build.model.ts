@@ -70,7 +70,7 @@ export class Build {
     }
 
     if (
-      this.jobs.find(job => job.status === 'failing') &&
+      this.jobs.find(job => job.status === 'failing' || job.status === 'errored') &&
       !this.jobs.find(job => job.status === 'queued')
     ) {
       return 'failing';
@@ -1,12 +1,15 @@
 package app
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"time"
 
-	"github.com/bleenco/abstruse/internal/auth"
 	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/pkg/tlsutil"
 	"github.com/bleenco/abstruse/server/api/render"
 	"github.com/bleenco/abstruse/worker/config"
 	"github.com/bleenco/abstruse/worker/http"
@@ -19,6 +22,9 @@ type App struct {
 	Client *http.Client
 	Logger *zap.SugaredLogger
 	API    *Server
+
+	keypair *tlsutil.Keypair
+	caPool  *x509.CertPool
 }
 
 // NewApp returns new App instance.
@@ -27,7 +33,7 @@ func NewApp(config *config.Config, logger *zap.Logger) (*App, error) {
 		Config: config,
 		Logger: logger.With(zap.String("type", "app")).Sugar(),
 	}
-	token, err := auth.JWT.CreateWorkerJWT(auth.WorkerClaims{ID: config.ID, Addr: config.GRPC.Addr})
+	token, err := config.ServerToken()
 	if err != nil {
 		return nil, err
 	}
@@ -46,6 +52,27 @@ func (a *App) Run() error {
 	errch := make(chan error, 1)
 	quitch := make(chan error, 1)
 
+	if a.Config.Auth.Token == "" && a.Config.Auth.EnrollmentToken != "" {
+		for {
+			err := a.enrollWorker()
+			if err == nil {
+				break
+			}
+			a.Logger.Errorf("error enrolling worker: %v", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+
+	for {
+		err := a.initCertificate()
+		if err == nil {
+			break
+		}
+		a.Logger.Errorf("error initializing certificate: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+	go a.rotateCertificate()
+
 	go func() {
 		if err := a.API.Run(); err != nil {
 			quitch <- err
@@ -76,6 +103,11 @@ func (a *App) Run() error {
 	return <-quitch
 }
 
+// Shutdown gracefully stops the worker node.
+func (a *App) Shutdown() {
+	a.API.Shutdown(time.Duration(a.Config.Scheduler.ShutdownTimeout) * time.Second)
+}
+
 func (a *App) sendAuthRequest() error {
 	type response struct {
 		Auth string `json:"auth"`
@@ -108,3 +140,72 @@ func (a *App) sendAuthRequest() error {
 
 	return fmt.Errorf("error connecting to abstruse server: %s", r.Message)
 }
+
+// enrollWorker exchanges enrollment token for worker credentials
+// and persists them into the config file.
+func (a *App) enrollWorker() error {
+	type form struct {
+		ID    string `json:"id"`
+		Addr  string `json:"addr"`
+		Token string `json:"token"`
+	}
+
+	type response struct {
+		Token  string `json:"token"`
+		Secret string `json:"secret"`
+		Status string `json:"status"`
+	}
+
+	body, err := json.Marshal(form{ID: a.Config.ID, Addr: a.Config.GRPC.Addr, Token: a.Config.Auth.EnrollmentToken})
+	if err != nil {
+		return err
+	}
+
+	req := &http.Request{
+		Method: "POST",
+		Path:   "/api/v1/workers/enroll",
+		Body:   bytes.NewReader(body),
+		Header: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	}
+
+	resp, err := a.Client.Req(context.Background(), req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != 200 {
+		var r render.Error
+		if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+			return err
+		}
+		return fmt.Errorf("error enrolling worker: %s", r.Message)
+	}
+
+	var r response
+	if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+		return err
+	}
+
+	a.Config.Auth.Token, a.Config.Auth.Secret = r.Token, r.Secret
+	if err := config.Save("auth.token", r.Token); err != nil {
+		return err
+	}
+	if err := config.Save("auth.secret", r.Secret); err != nil {
+		return err
+	}
+	if err := config.Save("auth.enrollmenttoken", ""); err != nil {
+		return err
+	}
+
+	client, err := http.NewClient(a.Config.Server.Addr, r.Token)
+	if err != nil {
+		return err
+	}
+	a.Client = client
+
+	a.Logger.Infof("worker enrolled, status: %s", r.Status)
+	return nil
+}
@@ -80,12 +80,12 @@ func authenticate(ctx context.Context, s *Server) (string, error) {
 		identifier := strings.Join(md["identifier"], "")
 		jwt := strings.Join(md["jwt"], "")
 
-		calcID, err := auth.GetWorkerIdentifierByJWT(jwt)
+		calcID, err := auth.GetWorkerIdentifierByJWT(jwt, s.config.Secret())
 		if err != nil {
 			return "", fmt.Errorf("invalid credentials")
 		}
 
-		if calcID == identifier {
+		if calcID == identifier && calcID == s.id {
 			return identifier, nil
 		}
 
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/worker/docker"
+	"google.golang.org/protobuf/proto"
+)
+
+// outputRetention defines how long job output is kept after job
+// is done, so the server can still reattach and fetch final status.
+const outputRetention = 10 * time.Minute
+
+// jobStream is a gRPC stream job output is sent to.
+type jobStream interface {
+	Send(*pb.JobResp) error
+	Context() context.Context
+}
+
+// output buffers job responses on disk with sequential offsets,
+// so they can be replayed from any offset when server reattaches.
+type output struct {
+	mu     sync.Mutex
+	file   *os.File
+	index  []int64
+	notify chan struct{}
+}
+
+func newOutput(id uint64) (*output, error) {
+	dir, err := docker.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, fmt.Sprintf("%s%d-*.log", docker.JobPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	return &output{file: file, notify: make(chan struct{})}, nil
+}
+
+// append writes response to the buffer and sets its offset.
+func (o *output) append(resp *pb.JobResp) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	resp.Offset = uint64(len(o.index))
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	pos := o.end()
+	if _, err := o.file.WriteAt(data, pos); err != nil {
+		return err
+	}
+	o.index = append(o.index, pos+int64(len(data)))
+
+	close(o.notify)
+	o.notify = make(chan struct{})
+	return nil
+}
+
+// read returns buffered responses starting at offset and a channel
+// which is closed when next response is appended.
+func (o *output) read(offset uint64) ([]*pb.JobResp, <-chan struct{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var resps []*pb.JobResp
+	for i := offset; i < uint64(len(o.index)) && len(resps) < 256; i++ {
+		var start int64
+		if i > 0 {
+			start = o.index[i-1]
+		}
+		data := make([]byte, o.index[i]-start)
+		if _, err := o.file.ReadAt(data, start); err != nil {
+			return nil, nil, err
+		}
+		resp := &pb.JobResp{}
+		if err := proto.Unmarshal(data, resp); err != nil {
+			return nil, nil, err
+		}
+		resps = append(resps, resp)
+	}
+
+	return resps, o.notify, nil
+}
+
+// close closes and removes the buffer file.
+func (o *output) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.file.Close()
+	return os.Remove(o.file.Name())
+}
+
+func (o *output) end() int64 {
+	if len(o.index) == 0 {
+		return 0
+	}
+	return o.index[len(o.index)-1]
+}
+
+// follow sends job output to the stream starting at offset until the
+// job is done. Job keeps running if the stream is closed meanwhile.
+func (s *Server) follow(out *output, offset uint64, stream jobStream) error {
+	for {
+		resps, notify, err := out.read(offset)
+		if err != nil {
+			return err
+		}
+		for _, resp := range resps {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			offset = resp.GetOffset() + 1
+			if resp.GetType() == pb.JobResp_Done {
+				return nil
+			}
+		}
+		if len(resps) > 0 {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// removeOutput removes job output if it was not replaced meanwhile.
+func (s *Server) removeOutput(id uint64, out *output) {
+	s.mu.Lock()
+	if s.outputs[id] == out {
+		delete(s.outputs, id)
+	}
+	s.mu.Unlock()
+
+	if err := out.close(); err != nil {
+		s.logger.Errorf("error removing output of job %d: %v", id, err)
+	}
+}
@@ -11,10 +11,10 @@ import (
 	"time"
 
 	pb "github.com/bleenco/abstruse/pb"
-	"github.com/bleenco/abstruse/pkg/fs"
 	"github.com/bleenco/abstruse/pkg/stats"
 	"github.com/bleenco/abstruse/worker/config"
 	"github.com/bleenco/abstruse/worker/docker"
+	"github.com/bleenco/abstruse/worker/executor"
 	"github.com/bleenco/abstruse/worker/git"
 	"github.com/golang/protobuf/ptypes/empty"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -22,51 +22,69 @@ import (
 	"github.com/logrusorgru/aurora"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // Server represents gRPC server.
 type Server struct {
-	mu       sync.Mutex
-	config   *config.Config
-	id       string
-	addr     string
-	listener net.Listener
-	server   *grpc.Server
-	app      *App
-	logger   *zap.SugaredLogger
-	jobs     map[uint64]*pb.Job
-	errch    chan error
+	mu        sync.Mutex
+	config    *config.Config
+	id        string
+	addr      string
+	listener  net.Listener
+	server    *grpc.Server
+	app       *App
+	logger    *zap.SugaredLogger
+	jobs      map[uint64]*pb.Job
+	outputs   map[uint64]*output
+	executors map[uint64]executor.Executor
+	errch     chan error
+	reaper    *docker.Reaper
+	mirror    *git.Mirror
+	wg        sync.WaitGroup
+	draining  bool
+	shutdown  bool
+	drainch   chan struct{}
+	quit      chan struct{}
 }
 
 // NewServer returns new gRPC server.
 func NewServer(config *config.Config, logger *zap.Logger, app *App) *Server {
-	return &Server{
-		config: config,
-		id:     config.ID,
-		addr:   config.GRPC.Addr,
-		app:    app,
-		logger: logger.With(zap.String("type", "server")).Sugar(),
-		jobs:   make(map[uint64]*pb.Job),
-		errch:  make(chan error),
+	s := &Server{
+		config:    config,
+		id:        config.ID,
+		addr:      config.GRPC.Addr,
+		app:       app,
+		logger:    logger.With(zap.String("type", "server")).Sugar(),
+		jobs:      make(map[uint64]*pb.Job),
+		outputs:   make(map[uint64]*output),
+		executors: make(map[uint64]executor.Executor),
+		errch:     make(chan error),
+		drainch:   make(chan struct{}),
+		quit:      make(chan struct{}),
 	}
+	s.reaper = docker.NewReaper(config.Reaper, s.tracked, logger)
+	if config.Git != nil && config.Git.MirrorDir != "" {
+		s.mirror = git.NewMirror(config.Git.MirrorDir)
+	}
+	return s
 }
 
 // Run starts the gRPC server.
 func (s *Server) Run() error {
 	var err error
 	grpcOpts := []grpc.ServerOption{}
-	certificate, err := tls.LoadX509KeyPair(s.config.TLS.Cert, s.config.TLS.Key)
-	if err != nil {
-		return err
-	}
 	s.listener, err = net.Listen("tcp", s.config.GRPC.Addr)
 	if err != nil {
 		return err
 	}
 	creds := credentials.NewTLS(&tls.Config{
-		Certificates:       []tls.Certificate{certificate},
-		InsecureSkipVerify: true,
+		GetCertificate: s.app.keypair.GetCertificate,
+		ClientCAs:      s.app.caPool,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		MinVersion:     tls.VersionTLS12,
 	})
 
 	grpcOpts = append(grpcOpts, grpc.Creds(creds))
@@ -82,6 +100,7 @@ func (s *Server) Run() error {
 	s.server = grpc.NewServer(grpcOpts...)
 	pb.RegisterAPIServer(s.server, s)
 	s.logger.Infof("grpc server listening on %s", s.config.GRPC.Addr)
+	go s.reaper.Run()
 
 	return s.server.Serve(s.listener)
 }
@@ -93,6 +112,10 @@ func (s *Server) Connect(ctx context.Context, in *empty.Empty) (*pb.HostInfo, er
 		return nil, err
 	}
 
+	s.mu.Lock()
+	max := s.config.Scheduler.MaxParallel
+	s.mu.Unlock()
+
 	return &pb.HostInfo{
 		Id:                   s.id,
 		Addr:                 s.addr,
@@ -109,7 +132,8 @@ func (s *Server) Connect(ctx context.Context, in *empty.Empty) (*pb.HostInfo, er
 		VirtualizationSystem: info.VirtualizationRole,
 		VirtualizationRole:   info.VirtualizationRole,
 		HostID:               info.HostID,
-		MaxParallel:          uint64(s.config.Scheduler.MaxParallel),
+		MaxParallel:          uint64(max),
+		Labels:               executor.Labels(s.config.Executor),
 	}, nil
 }
 
@@ -120,9 +144,14 @@ func (s *Server) Usage(stream pb.API_UsageServer) error {
 
 	send := func(stream pb.API_UsageServer) error {
 		cpu, mem := stats.GetUsageStats()
+		s.mu.Lock()
+		draining := s.draining
+		s.mu.Unlock()
 		if err := stream.Send(&pb.UsageStats{
-			Cpu: cpu,
-			Mem: mem,
+			Cpu:      cpu,
+			Mem:      mem,
+			Draining: draining,
+			Freed:    s.reaper.Freed(),
 		}); err != nil {
 			return err
 		}
@@ -141,45 +170,115 @@ func (s *Server) Usage(stream pb.API_UsageServer) error {
 			errch <- err
 		}
 		ticker := time.NewTicker(5 * time.Second)
-		for range ticker.C {
+		defer ticker.Stop()
+		drainch := s.drainch
+		for {
+			select {
+			case <-ticker.C:
+			case <-drainch:
+				drainch = nil
+			}
 			if err := send(stream); err != nil {
-				ticker.Stop()
 				errch <- err
+				return
 			}
 		}
 	}()
 
-	err := <-errch
-	s.logger.Errorf("lost connection with server: %s", err.Error())
-	s.errch <- err
-	return err
+	select {
+	case err := <-errch:
+		s.logger.Errorf("lost connection with server: %s", err.Error())
+		s.errch <- err
+		return err
+	case <-s.quit:
+		return nil
+	}
 }
 
-// StartJob gRPC method.
+// StartJob gRPC method. Job runs independently of the stream, when
+// stream is lost server can reattach to the job using AttachJob.
 func (s *Server) StartJob(job *pb.Job, stream pb.API_StartJobServer) error {
 	name := fmt.Sprintf("abstruse-job-%d", job.GetId())
 	s.logger.Infof("starting job %d with name %s", job.Id, name)
 
 	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		s.logger.Infof("worker is shutting down, rejecting job %d", job.Id)
+		return stream.Send(interrupted(job, "worker shutdown"))
+	}
+	out, err := newOutput(job.Id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
 	if _, ok := s.jobs[job.Id]; ok {
-		docker.StopContainer(name)
+		s.cleanup(job.Id)
 		delete(s.jobs, job.Id)
 	}
+	if prev, ok := s.outputs[job.Id]; ok {
+		prev.close()
+	}
 	s.jobs[job.Id] = job
+	s.outputs[job.Id] = out
+	s.wg.Add(1)
 	s.mu.Unlock()
 
+	go func() {
+		defer s.wg.Done()
+		s.runJob(job, name, out)
+		time.AfterFunc(outputRetention, func() {
+			s.removeOutput(job.Id, out)
+		})
+	}()
+
+	if err := s.follow(out, 0, stream); err != nil {
+		s.logger.Errorf("lost stream of job %d, job keeps running: %v", job.Id, err)
+		return err
+	}
+
+	return nil
+}
+
+// AttachJob gRPC method.
+func (s *Server) AttachJob(req *pb.JobAttachReq, stream pb.API_AttachJobServer) error {
+	s.mu.Lock()
+	out, ok := s.outputs[req.GetId()]
+	s.mu.Unlock()
+
+	if !ok {
+		return status.Errorf(codes.NotFound, "job %d not found", req.GetId())
+	}
+
+	s.logger.Infof("attaching to job %d from offset %d", req.GetId(), req.GetOffset())
+	return s.follow(out, req.GetOffset(), stream)
+}
+
+// runJob runs the job and writes its output and final status to out.
+// Job is untracked when it is done, unless it was started again
+// meanwhile.
+func (s *Server) runJob(job *pb.Job, name string, buf *output) {
 	defer func() {
 		s.mu.Lock()
-		if _, ok := s.jobs[job.Id]; ok {
-			docker.StopContainer(name)
+		if s.jobs[job.Id] == job {
 			delete(s.jobs, job.Id)
 		}
 		s.mu.Unlock()
 	}()
 
+	write := func(resp *pb.JobResp) error {
+		err := buf.append(resp)
+		if err != nil {
+			s.logger.Errorf("error writing output of job %d: %v", job.Id, err)
+		}
+		return err
+	}
+
 	logch := make(chan []byte, 1024)
+	logdone := make(chan struct{})
 
 	go func(job *pb.Job) {
+		defer close(logdone)
 		for output := range logch {
 			out := string(output)
 
@@ -189,16 +288,30 @@ func (s *Server) StartJob(job *pb.Job, stream pb.API_StartJobServer) error {
 				}
 			}
 
-			log := &pb.JobResp{Id: job.GetId(), Content: []byte(out), Type: pb.JobResp_Log}
-			if err := stream.Send(log); err != nil {
+			if err := write(&pb.JobResp{Id: job.GetId(), Content: []byte(out), Type: pb.JobResp_Log}); err != nil {
 				break
 			}
 		}
 	}(job)
 
-	logch <- []byte(yellow(fmt.Sprintf("==> Starting job %d in %s...\r\n", job.GetId(), name)))
+	resp := s.execute(job, name, logch)
+	<-logdone
+	write(resp)
+}
+
+// execute runs the job and returns final response with job status.
+// Channel logch is closed when execute returns.
+func (s *Server) execute(job *pb.Job, name string, logch chan<- []byte) *pb.JobResp {
+	defer close(logch)
+
+	failing := &pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusFailing}
+	fail := func(err error) *pb.JobResp {
+		logch <- []byte(red(fmt.Sprintf("\r\n==> %s\r\n", err.Error())))
+		s.logger.Infof("job %d with name %s done with status failing: %v", job.Id, name, err)
+		return failing
+	}
 
-	image := job.Image
+	logch <- []byte(yellow(fmt.Sprintf("==> Starting job %d in %s...\r\n", job.GetId(), name)))
 
 	var env []string
 	for _, e := range job.GetEnv() {
@@ -206,60 +319,114 @@ func (s *Server) StartJob(job *pb.Job, stream pb.API_StartJobServer) error {
 	}
 
 	logch <- []byte(yellow("==> Creating temp directory to mount volume... "))
-	dir, err := fs.TempDir()
+	dir, err := docker.WorkspaceDir(job.Id)
 	if err != nil {
-		return err
+		return fail(err)
 	}
 	defer os.RemoveAll(dir)
 	logch <- []byte(yellow("done\r\n"))
 
-	if !job.GetSshClone() {
-		logch <- []byte(yellow(fmt.Sprintf("==> Cloning repository %s ref: %s sha: %s... ", job.GetUrl(), job.GetRef(), job.GetCommitSHA())))
-	} else {
-		logch <- []byte(yellow(fmt.Sprintf("==> Cloning repository %s ref: %s sha: %s... ", job.GetSshURL(), job.GetRef(), job.GetCommitSHA())))
+	if c := job.GetCredentials(); c != nil {
+		// repository is cloned through git proxy of the server.
+		if c.Url, err = git.ResolveURL(s.config.Server.Addr, c.GetUrl()); err != nil {
+			return fail(err)
+		}
 	}
 
-	if err := git.CloneRepository(
-		job.GetUrl(),
-		job.GetRef(),
-		job.GetCommitSHA(),
-		job.GetProviderToken(),
-		dir,
-		job.GetSshURL(),
-		[]byte(job.GetSshPrivateKey()),
-		job.GetSshClone(),
-	); err != nil {
-		return err
+	e, err := executor.New(name, job, env, s.config)
+	if err != nil {
+		return fail(err)
 	}
-	logch <- []byte(yellow("done\r\n"))
+	if c, ok := e.(executor.Cloner); !ok || !c.ClonesRepository() {
+		if !job.GetSshClone() {
+			logch <- []byte(yellow(fmt.Sprintf("==> Cloning repository %s ref: %s sha: %s... ", job.GetUrl(), job.GetRef(), job.GetCommitSHA())))
+		} else {
+			logch <- []byte(yellow(fmt.Sprintf("==> Cloning repository %s ref: %s sha: %s... ", job.GetSshURL(), job.GetRef(), job.GetCommitSHA())))
+		}
 
-	logch <- []byte(yellow(fmt.Sprintf("==> Pulling image %s... ", image)))
-	if err := docker.PullImage(image, s.config.Registry); err != nil {
-		logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-	} else {
+		opts := git.JobOptions(job)
+		cloned := false
+		if s.mirror != nil {
+			if err := s.mirror.Clone(dir, opts); err != nil {
+				s.logger.Warnf("error cloning repository of job %d from mirror, cloning directly: %v", job.Id, err)
+			} else {
+				cloned = true
+			}
+		}
+		if !cloned {
+			if err := git.CloneRepository(dir, opts); err != nil {
+				return fail(err)
+			}
+		}
 		logch <- []byte(yellow("done\r\n"))
 	}
+	defer e.Cleanup(context.Background())
 
-	ok := true
 	s.mu.Lock()
-	_, ok = s.jobs[job.Id]
+	ok := s.jobs[job.Id] == job
+	shutdown := s.shutdown
+	if ok && !shutdown {
+		s.executors[job.Id] = e
+	}
 	s.mu.Unlock()
 
+	if shutdown {
+		return interrupted(job, "worker shutdown")
+	}
 	if !ok {
-		return nil
+		return failing
 	}
+	defer func() {
+		s.mu.Lock()
+		if s.executors[job.Id] == e {
+			delete(s.executors, job.Id)
+		}
+		s.mu.Unlock()
+	}()
 
-	logch <- []byte(yellow(fmt.Sprintf("==> Starting container %s...\r\n", name)))
-	if err := docker.RunContainer(name, image, job, s.config, env, dir, logch); err != nil {
-		stream.Send(&pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusFailing})
+	if err := executor.Run(context.Background(), e, job, s.config, dir, logch); err != nil {
+		s.mu.Lock()
+		shutdown := s.shutdown
+		s.mu.Unlock()
+		if shutdown {
+			s.logger.Infof("job %d with name %s stopped due to worker shutdown", job.Id, name)
+			return interrupted(job, "worker shutdown")
+		}
+		if perr, ok := err.(*executor.PhaseError); ok && perr.Errored() {
+			if perr.OOMKilled {
+				s.logger.Infof("job %d with name %s done with status errored (OOM killed)", job.Id, name)
+			} else {
+				s.logger.Infof("job %d with name %s done with status errored", job.Id, name)
+			}
+			return &pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusErrored, Reason: perr.Error()}
+		}
 		s.logger.Infof("job %d with name %s done with status failing", job.Id, name)
-		return err
+		return failing
 	}
 
-	stream.Send(&pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusPassing})
 	s.logger.Infof("job %d with name %s done with status success", job.Id, name)
+	return &pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusPassing}
+}
 
-	return nil
+// SetCapacity gRPC method.
+func (s *Server) SetCapacity(ctx context.Context, in *pb.Capacity) (*pb.Capacity, error) {
+	max := int(in.GetMaxParallel())
+	if max < 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "max parallel must be greater than 0")
+	}
+
+	// config is saved first, so capacity in use is never lost on restart.
+	s.mu.Lock()
+	if err := config.Save("scheduler.maxparallel", max); err != nil {
+		s.mu.Unlock()
+		s.logger.Errorf("error saving config: %v", err)
+		return nil, status.Errorf(codes.Internal, "error saving config: %v", err)
+	}
+	s.config.Scheduler.MaxParallel = max
+	s.mu.Unlock()
+	s.logger.Infof("max parallel jobs set to %d", max)
+
+	return &pb.Capacity{MaxParallel: uint64(max)}, nil
 }
 
 // StopJob gRPC method.
@@ -274,20 +441,113 @@ func (s *Server) StopJob(ctx context.Context, job *pb.Job) (*pb.JobStopResp, err
 		s.mu.Unlock()
 	}()
 
-	if _, exists := docker.ContainerExists(name); exists {
-		if err := docker.StopContainer(name); err != nil {
-			return &pb.JobStopResp{Stopped: false}, nil
-		}
-		return &pb.JobStopResp{Stopped: true}, nil
+	s.mu.Lock()
+	e, ok := s.executors[job.Id]
+	s.mu.Unlock()
+	if !ok {
+		return &pb.JobStopResp{Stopped: false}, nil
 	}
-
-	return &pb.JobStopResp{Stopped: false}, nil
+	if err := e.Cleanup(ctx); err != nil {
+		return &pb.JobStopResp{Stopped: false}, nil
+	}
+	return &pb.JobStopResp{Stopped: true}, nil
 }
 
 func (s *Server) Error() chan error {
 	return s.errch
 }
 
+// Shutdown stops accepting new jobs, notifies abstruse server that
+// worker is draining and waits for running jobs to finish. Jobs still
+// running when timeout expires are stopped and reported as interrupted
+// so the server can reschedule them.
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		return
+	}
+	s.draining = true
+	running := len(s.jobs)
+	s.mu.Unlock()
+	close(s.drainch)
+
+	s.logger.Infof("draining worker, waiting for %d running jobs to finish", running)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.mu.Lock()
+		s.shutdown = true
+		for id := range s.jobs {
+			s.logger.Infof("stopping job %d", id)
+			s.cleanup(id)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+			s.logger.Errorf("timed out waiting for jobs to report status")
+		}
+	}
+
+	// let job streams deliver final status before closing connections.
+	close(s.quit)
+	if s.server != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			s.server.Stop()
+		}
+	}
+	s.logger.Infof("worker shut down")
+}
+
+// tracked returns true when job is running on this worker
+// or its output is still buffered.
+func (s *Server) tracked(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, running := s.jobs[id]
+	_, buffered := s.outputs[id]
+	return running || buffered
+}
+
+// cleanup stops running job by cleaning up its executor.
+// It must be called with s.mu held.
+func (s *Server) cleanup(id uint64) {
+	e, ok := s.executors[id]
+	if !ok {
+		return
+	}
+	delete(s.executors, id)
+	go func() {
+		if err := e.Cleanup(context.Background()); err != nil {
+			s.logger.Errorf("error stopping job %d: %v", id, err)
+		}
+	}()
+}
+
+func interrupted(job *pb.Job, reason string) *pb.JobResp {
+	return &pb.JobResp{Id: job.GetId(), Type: pb.JobResp_Done, Status: pb.JobResp_StatusInterrupted, Reason: reason}
+}
+
 func yellow(str string) string {
 	return aurora.Bold(aurora.Yellow(str)).String()
 }
+
+func red(str string) string {
+	return aurora.Bold(aurora.Red(str)).String()
+}
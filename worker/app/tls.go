@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/fs"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/pkg/tlsutil"
+	"github.com/bleenco/abstruse/server/api/render"
+	"github.com/bleenco/abstruse/worker/http"
+)
+
+// initCertificate loads worker certificate from disk and requests
+// a new one from the abstruse server when certificate is missing,
+// not issued by server CA or about to expire.
+func (a *App) initCertificate() error {
+	if fs.Exists(a.Config.TLS.CA) {
+		keypair, err := tlsutil.LoadKeypair(a.Config.TLS.Cert, a.Config.TLS.Key)
+		if err == nil {
+			pool, err := tlsutil.LoadCertPool(a.Config.TLS.CA)
+			if err == nil && a.verifyCertificate(keypair.Leaf(), pool) == nil && !keypair.NeedsRenewal() {
+				a.keypair, a.caPool = keypair, pool
+				return nil
+			}
+		}
+	}
+
+	return a.enroll()
+}
+
+// enroll sends certificate signing request to the abstruse server,
+// persists signed certificate and replaces the one in use.
+func (a *App) enroll() error {
+	type form struct {
+		CSR string `json:"csr"`
+	}
+
+	type response struct {
+		Cert string `json:"cert"`
+		CA   string `json:"ca"`
+	}
+
+	keyPEM, csrPEM, err := tlsutil.GenerateCSR(a.Config.ID)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(form{CSR: string(csrPEM)})
+	if err != nil {
+		return err
+	}
+
+	req := &http.Request{
+		Method: "POST",
+		Path:   "/api/v1/workers/certificate",
+		Body:   bytes.NewReader(body),
+		Header: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	}
+
+	resp, err := a.Client.Req(context.Background(), req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != 200 {
+		var r render.Error
+		if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+			return err
+		}
+		return fmt.Errorf("error requesting certificate from abstruse server: %s", r.Message)
+	}
+
+	var r response
+	if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(r.CA)) {
+		return fmt.Errorf("invalid CA certificate received from abstruse server")
+	}
+	if fs.Exists(a.Config.TLS.CA) {
+		// CA is pinned after first enrollment, never accept a different one.
+		current, err := ioutil.ReadFile(a.Config.TLS.CA)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(bytes.TrimSpace(current), bytes.TrimSpace([]byte(r.CA))) {
+			return fmt.Errorf("CA certificate received from abstruse server does not match %s", a.Config.TLS.CA)
+		}
+	}
+
+	cert, err := tls.X509KeyPair([]byte(r.Cert), keyPEM)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	if err := a.verifyCertificate(leaf, pool); err != nil {
+		return err
+	}
+
+	if err := fs.WriteFile(a.Config.TLS.CA, r.CA); err != nil {
+		return err
+	}
+	if err := tlsutil.SaveKeypair(a.Config.TLS.Cert, a.Config.TLS.Key, []byte(r.Cert), keyPEM); err != nil {
+		return err
+	}
+
+	if a.keypair == nil {
+		if a.keypair, err = tlsutil.NewKeypair(cert); err != nil {
+			return err
+		}
+	} else if err := a.keypair.Set(cert); err != nil {
+		return err
+	}
+	a.caPool = pool
+
+	a.Logger.Infof("received certificate from abstruse server, valid until %s", leaf.NotAfter)
+	return nil
+}
+
+// rotateCertificate periodically checks certificate expiry and
+// renews it without restarting the gRPC server.
+func (a *App) rotateCertificate() {
+	ticker := time.NewTicker(time.Hour)
+	for range ticker.C {
+		if !a.keypair.NeedsRenewal() {
+			continue
+		}
+		if err := a.enroll(); err != nil {
+			a.Logger.Errorf("error renewing certificate: %v", err)
+		}
+	}
+}
+
+func (a *App) verifyCertificate(leaf *x509.Certificate, pool *x509.CertPool) error {
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:   a.Config.ID,
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
@@ -8,42 +8,21 @@ import (
 	"path/filepath"
 	"strings"
 
-	api "github.com/bleenco/abstruse/pb"
-	"github.com/bleenco/abstruse/pkg/fs"
 	gzip "github.com/klauspost/pgzip"
 )
 
-func SaveCache(job *api.Job, dir string) (string, error) {
-	fileName := strings.ReplaceAll(fmt.Sprintf("%s-%s.tgz", job.GetRepoName(), job.GetBranch()), "/", "_")
-	out := filepath.Join(dir, fileName)
-
-	if fs.Exists(out) {
-		if err := os.RemoveAll(out); err != nil {
-			return out, err
-		}
-	}
-
-	return out, createArchive(job.GetCache(), out)
-}
-
-func createArchive(folders []string, outPath string) error {
-	out, err := os.Create(outPath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	gw, err := gzip.NewWriterLevel(out, gzip.BestSpeed)
+// createArchive writes files at paths relative to workspace directory
+// dir as gzipped tar archive to w.
+func createArchive(w io.Writer, dir string, folders []string) error {
+	gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 	if err != nil {
 		return err
 	}
-	defer gw.Close()
 
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 
 	for _, folder := range folders {
-		folder = filepath.Join(filepath.Dir(outPath), folder)
+		folder = filepath.Join(dir, folder)
 		if err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -66,7 +45,7 @@ func createArchive(folders []string, outPath string) error {
 				return err
 			}
 
-			header.Name = strings.TrimPrefix(path, fmt.Sprintf("%s/", filepath.Dir(outPath)))
+			header.Name = strings.TrimPrefix(path, fmt.Sprintf("%s/", dir))
 
 			if err := tw.WriteHeader(header); err != nil {
 				return err
@@ -79,6 +58,7 @@ func createArchive(folders []string, outPath string) error {
 				if err != nil {
 					return err
 				}
+				defer file.Close()
 
 				if _, err := io.Copy(tw, file); err != nil {
 					return err
@@ -91,5 +71,145 @@ func createArchive(folders []string, outPath string) error {
 		}
 	}
 
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// extractArchive unpacks gzipped tar archive read from r into workspace
+// directory dir. Entries and symlinks pointing outside of the workspace
+// and entries whose parent path goes through a symlink are rejected.
+func extractArchive(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, header.Name)
+		if !inside(dir, path) {
+			return fmt.Errorf("archive entry %s is outside of the workspace", header.Name)
+		}
+		if path == filepath.Clean(dir) {
+			continue
+		}
+		if err := checkParents(dir, path); err != nil {
+			return fmt.Errorf("archive entry %s: %v", header.Name, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+				return fmt.Errorf("archive entry %s: %s is not a directory", header.Name, path)
+			}
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(path, tr, header); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if !inside(dir, target) {
+				return fmt.Errorf("archive symlink %s points outside of the workspace", header.Name)
+			}
+			if err := removeFile(path); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkParents checks that no existing parent directory of path inside
+// workspace directory dir is a symlink, so entries are never written
+// through symlinks of the workspace.
+func checkParents(dir, path string) error {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	current := filepath.Clean(dir)
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, name)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s is a symlink", current)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("parent %s is not a directory", current)
+		}
+	}
 	return nil
 }
+
+// writeFile writes file at path, existing file or symlink is replaced
+// and never followed.
+func writeFile(path string, r io.Reader, header *tar.Header) error {
+	if err := removeFile(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, header.ModTime, header.ModTime)
+}
+
+// removeFile removes file or symlink at path, directories are not
+// replaced.
+func removeFile(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return os.Remove(path)
+}
+
+// inside checks if path is dir or inside of it.
+func inside(dir, path string) bool {
+	dir, path = filepath.Clean(dir), filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
@@ -2,71 +2,146 @@ package cache
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
-	"github.com/bleenco/abstruse/internal/auth"
-	api "github.com/bleenco/abstruse/pb"
-	"github.com/bleenco/abstruse/pkg/lib"
-	"github.com/bleenco/abstruse/server/api/render"
 	"github.com/bleenco/abstruse/worker/config"
 	"github.com/bleenco/abstruse/worker/http"
-	"github.com/mholt/archiver/v3"
 )
 
-func DownloadCache(config *config.Config, job *api.Job, dir string) (string, error) {
-	fileName := strings.ReplaceAll(fmt.Sprintf("%s-%s.tgz", job.GetRepoName(), job.GetBranch()), "/", "_")
-	outPath := filepath.Join(dir, fileName)
-
-	out, err := os.Create(outPath)
+// DownloadCache downloads cache with key, or the most recent cache with
+// key starting with one of restore keys when there is none, and unpacks
+// it into workspace directory dir. Archive is downloaded into temporary
+// file first and verified by its checksum before it is unpacked.
+// Interrupted download is resumed with range request. It returns key of
+// restored cache.
+func DownloadCache(config *config.Config, token, key string, restoreKeys []string, dir string) (string, error) {
+	client, err := newClient(config, token)
 	if err != nil {
-		return outPath, err
+		return "", err
 	}
-	defer out.Close()
 
-	token, err := auth.JWT.CreateWorkerJWT(auth.WorkerClaims{
-		ID:   config.ID,
-		Addr: config.GRPC.Addr,
+	ctx := context.Background()
+	query := url.Values{"key": {key}, "restore": restoreKeys}
+	resp, err := client.Req(ctx, &http.Request{
+		Method: "GET",
+		Path:   "/api/v1/workers/cache?" + query.Encode(),
 	})
-	if err != nil {
-		return outPath, err
+	if err == nil && resp.Status != 200 && resp.Status != 307 {
+		return "", decodeResponse(resp, nil, nil)
 	}
-
-	client, err := http.NewClient(config.Server.Addr, token)
 	if err != nil {
-		return outPath, err
+		return "", err
 	}
+	cacheKey, checksum := resp.Header.Get("X-Cache-Key"), resp.Header.Get("X-Checksum")
 
-	req := &http.Request{
-		Method: "GET",
-		Path:   fmt.Sprintf("/api/v1/workers/cache?file=%s", fileName),
+	r := &rangeReader{validator: resp.Header.Get("ETag")}
+	if resp.Status == 307 {
+		// archive is downloaded directly from the storage.
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		r.get = func(header map[string][]string) (*http.Response, error) {
+			return http.ReqURL(ctx, &http.Request{Method: "GET", Path: location, Header: header})
+		}
+		if resp, err = r.get(nil); err == nil && resp.Status != 200 {
+			resp.Body.Close()
+			return "", fmt.Errorf("storage responded with status %d", resp.Status)
+		}
+		if err != nil {
+			return "", err
+		}
+		r.validator = resp.Header.Get("ETag")
+	} else {
+		path := "/api/v1/workers/cache?" + url.Values{"key": {cacheKey}}.Encode()
+		r.get = func(header map[string][]string) (*http.Response, error) {
+			return client.Req(ctx, &http.Request{Method: "GET", Path: path, Header: header})
+		}
 	}
+	r.body = resp.Body
+	if r.validator == "" {
+		r.validator = resp.Header.Get("Last-Modified")
+	}
+	defer r.Close()
 
-	resp, err := client.Req(context.Background(), req, nil)
+	archive, err := ioutil.TempFile("", "abstruse-cache-")
 	if err != nil {
-		return outPath, err
+		return "", err
 	}
-	defer resp.Body.Close()
+	defer os.Remove(archive.Name())
+	defer archive.Close()
 
-	if resp.Status != 200 {
-		var r render.Error
-		if err := lib.DecodeJSON(resp.Body, &r); err != nil {
-			return outPath, err
-		}
-
-		return outPath, fmt.Errorf(r.Message)
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, h), r); err != nil {
+		return "", err
+	}
+	if checksum != "" && checksum != hex.EncodeToString(h.Sum(nil)) {
+		return "", fmt.Errorf("cache checksum mismatch")
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
+	if err := extractArchive(archive, dir); err != nil {
+		return "", err
+	}
+
+	return cacheKey, nil
+}
 
-	if _, err := io.Copy(out, resp.Body); err != nil {
-		return outPath, err
+// rangeReader reads response body of the download and resumes it with
+// range request from the current offset when reading fails. Resumed
+// download fails when the file has changed in between.
+type rangeReader struct {
+	get       func(map[string][]string) (*http.Response, error)
+	validator string
+	body      io.ReadCloser
+	offset    int64
+	retries   int
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF || n > 0 {
+			return n, err
+		}
+		if r.retries >= maxRetries {
+			return 0, err
+		}
+		r.retries++
+		time.Sleep(time.Duration(1<<uint(r.retries-1)) * time.Second)
+		if rerr := r.resume(); rerr != nil {
+			return 0, fmt.Errorf("%v, resuming download failed: %v", err, rerr)
+		}
 	}
+}
 
-	if err := out.Close(); err != nil {
-		return outPath, err
+func (r *rangeReader) resume() error {
+	r.body.Close()
+	r.body = ioutil.NopCloser(&io.LimitedReader{})
+
+	header := map[string][]string{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+	if r.validator != "" {
+		header["If-Range"] = []string{r.validator}
 	}
+	resp, err := r.get(header)
+	if err != nil {
+		return err
+	}
+	if resp.Status != 206 {
+		resp.Body.Close()
+		return fmt.Errorf("download responded with status %d", resp.Status)
+	}
+	r.body = resp.Body
+	return nil
+}
 
-	return outPath, archiver.Unarchive(outPath, dir)
+func (r *rangeReader) Close() error {
+	return r.body.Close()
 }
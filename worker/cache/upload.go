@@ -3,90 +3,229 @@ package cache
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"os"
-	"path/filepath"
+	"net/url"
+	"time"
 
-	"github.com/bleenco/abstruse/internal/auth"
 	"github.com/bleenco/abstruse/pkg/lib"
-	"github.com/bleenco/abstruse/server/api/render"
 	"github.com/bleenco/abstruse/worker/config"
 	"github.com/bleenco/abstruse/worker/http"
 )
 
-func UploadCache(config *config.Config, filePath string) error {
-	type response struct {
-		Status bool `json:"status"`
-	}
+// chunkSize is size of cache upload chunks.
+const chunkSize = 16 << 20
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// maxRetries is number of retries of interrupted chunk uploads and
+// downloads.
+const maxRetries = 5
+
+type uploadResponse struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Presign bool   `json:"presign"`
+}
+
+type urlResponse struct {
+	URL string `json:"url"`
+}
 
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+// UploadCache archives paths relative to workspace directory dir and
+// uploads the archive with key to abstruse server while it is being
+// created. Job token limits access to caches of the job repository.
+// Archive is uploaded in chunks verified by their checksums,
+// interrupted chunk uploads are retried. Existing cache with the same
+// key is replaced. It returns size of uploaded archive.
+func UploadCache(config *config.Config, token, key string, paths []string, dir string) (int64, error) {
+	client, err := newClient(config, token)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	ctx := context.Background()
 
-	if _, err := io.Copy(part, file); err != nil {
-		return err
+	var upload uploadResponse
+	resp, err := client.Req(ctx, &http.Request{
+		Method: "POST",
+		Path:   "/api/v1/workers/cache/uploads?" + url.Values{"key": {key}}.Encode(),
+	})
+	if err := decodeResponse(resp, err, &upload); err != nil {
+		return 0, err
 	}
 
-	if err := writer.Close(); err != nil {
-		return err
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(createArchive(w, dir, paths))
+	}()
+	defer r.Close()
+
+	h := sha256.New()
+	src := io.TeeReader(r, h)
+	buf := make([]byte, chunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if err := uploadChunk(ctx, client, upload, size, buf[:n]); err != nil {
+				return size, err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
 	}
 
-	req := &http.Request{
+	query := url.Values{
+		"size":     {fmt.Sprint(size)},
+		"checksum": {hex.EncodeToString(h.Sum(nil))},
+	}
+	resp, err = client.Req(ctx, &http.Request{
 		Method: "POST",
-		Path:   "/api/v1/workers/cache",
-		Body:   body,
+		Path:   fmt.Sprintf("/api/v1/workers/cache/uploads/%s/complete?%s", upload.ID, query.Encode()),
+	})
+	return size, decodeResponse(resp, err, nil)
+}
+
+// uploadChunk uploads chunk of the archive at offset, chunk is retried
+// with backoff when the request fails. When presigned URLs are enabled,
+// chunk is uploaded directly to the storage.
+func uploadChunk(ctx context.Context, client *http.Client, upload uploadResponse, offset int64, chunk []byte) error {
+	var err error
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(1<<uint(i-1)) * time.Second)
+		}
+		var resp *http.Response
+		if upload.Presign {
+			resp, err = putChunkURL(ctx, client, upload.ID, offset, chunk)
+		} else {
+			resp, err = putChunk(ctx, client, upload.ID, offset, chunk)
+		}
+		if err == nil {
+			return nil
+		}
+		if resp != nil && (resp.Status == 404 || resp.Status == 409) {
+			return err
+		}
+	}
+	return err
+}
+
+// putChunk uploads chunk to abstruse server which verifies it by its
+// SHA-256 checksum.
+func putChunk(ctx context.Context, client *http.Client, id string, offset int64, chunk []byte) (*http.Response, error) {
+	sum := sha256.Sum256(chunk)
+	resp, err := client.Req(ctx, &http.Request{
+		Method: "PUT",
+		Path:   fmt.Sprintf("/api/v1/workers/cache/uploads/%s?offset=%d", id, offset),
+		Body:   bytes.NewReader(chunk),
 		Header: map[string][]string{
-			"Content-Type": {writer.FormDataContentType()},
+			"Content-Type": {"application/octet-stream"},
+			"X-Checksum":   {hex.EncodeToString(sum[:])},
 		},
+	})
+	var upload uploadResponse
+	return resp, decodeResponse(resp, err, &upload)
+}
+
+// putChunkURL uploads chunk to presigned URL issued by abstruse server,
+// storage verifies it by its MD5 checksum.
+func putChunkURL(ctx context.Context, client *http.Client, id string, offset int64, chunk []byte) (*http.Response, error) {
+	var u urlResponse
+	resp, err := client.Req(ctx, &http.Request{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/workers/cache/uploads/%s/url?offset=%d", id, offset),
+	})
+	if err := decodeResponse(resp, err, &u); err != nil {
+		return resp, err
 	}
 
-	token, err := auth.JWT.CreateWorkerJWT(auth.WorkerClaims{
-		ID:   config.ID,
-		Addr: config.GRPC.Addr,
+	sum := md5.Sum(chunk)
+	resp, err = http.ReqURL(ctx, &http.Request{
+		Method: "PUT",
+		Path:   u.URL,
+		Body:   bytes.NewReader(chunk),
+		Header: map[string][]string{
+			"Content-Type": {"application/octet-stream"},
+			"Content-MD5":  {base64.StdEncoding.EncodeToString(sum[:])},
+		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	resp.Body.Close()
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("storage responded with status %d", resp.Status)
+	}
+	return resp, nil
+}
 
-	client, err := http.NewClient(config.Server.Addr, token)
+// CacheExists checks if cache with key exists on abstruse server.
+func CacheExists(config *config.Config, token, key string) (bool, error) {
+	client, err := newClient(config, token)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	req := &http.Request{
+		Method: "HEAD",
+		Path:   "/api/v1/workers/cache?" + url.Values{"key": {key}}.Encode(),
 	}
 
 	resp, err := client.Req(context.Background(), req, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer resp.Body.Close()
 
-	if resp.Status == 200 {
-		var r response
-		if err := lib.DecodeJSON(resp.Body, &r); err != nil {
-			return err
-		}
-
-		if r.Status {
-			return nil
-		}
+	switch resp.Status {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error checking cache on abstruse server: status %d", resp.Status)
+	}
+}
 
-		return fmt.Errorf("unknown status")
+// newClient returns client authenticated with job token.
+func newClient(config *config.Config, token string) (*http.Client, error) {
+	if token == "" {
+		return nil, fmt.Errorf("job token not provided")
 	}
+	return http.NewClient(config.Server.Addr, token)
+}
 
-	var r render.Error
-	if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+// errorResponse is error response of abstruse server.
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// decodeResponse decodes JSON response into out or returns error of the
+// response.
+func decodeResponse(resp *http.Response, err error, out interface{}) error {
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	return fmt.Errorf("error uploading cache to abstruse server: %s", r.Message)
+	if resp.Status != 200 {
+		var r errorResponse
+		if err := lib.DecodeJSON(resp.Body, &r); err != nil {
+			return fmt.Errorf("abstruse server responded with status %d", resp.Status)
+		}
+		return errors.New(r.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return lib.DecodeJSON(resp.Body, out)
 }
@@ -3,14 +3,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/bleenco/abstruse/internal/auth"
 	"github.com/bleenco/abstruse/internal/version"
 	"github.com/bleenco/abstruse/pkg/fs"
 	"github.com/bleenco/abstruse/pkg/lib"
-	"github.com/bleenco/abstruse/pkg/tlsutil"
 	"github.com/bleenco/abstruse/worker/app"
 	"github.com/bleenco/abstruse/worker/config"
 	"github.com/bleenco/abstruse/worker/docker"
@@ -63,6 +64,8 @@ func newApplication(
 
 func (a application) run() error {
 	errch := make(chan error, 1)
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		if err := a.app.Run(); err != nil {
@@ -70,7 +73,13 @@ func (a application) run() error {
 		}
 	}()
 
-	return <-errch
+	select {
+	case err := <-errch:
+		return err
+	case <-sigch:
+		a.app.Shutdown()
+		return nil
+	}
 }
 
 // Execute executes the root command.
@@ -88,11 +97,36 @@ func init() {
 	rootCmd.PersistentFlags().String("grpc-addr", "0.0.0.0:3330", "gRPC server listen address")
 	rootCmd.PersistentFlags().String("tls-cert", "cert-worker.pem", "path to SSL certificate file")
 	rootCmd.PersistentFlags().String("tls-key", "key-worker.pem", "path to SSL private key file")
+	rootCmd.PersistentFlags().String("tls-ca", "ca-worker.pem", "path to abstruse server CA certificate file")
 	rootCmd.PersistentFlags().Int("scheduler-maxparallel", 5, "scheduler max parallel option defines how many jobs can run in parallel")
+	rootCmd.PersistentFlags().Int("scheduler-shutdowntimeout", 300, "time in seconds to wait for running jobs to finish on shutdown")
 	rootCmd.PersistentFlags().String("auth-jwtsecret", lib.RandomString(), "JWT authentication secret key")
+	rootCmd.PersistentFlags().String("auth-enrollmenttoken", "", "enrollment token used to register worker on abstruse server")
 	rootCmd.PersistentFlags().String("registry-addr", "https://registry-1.docker.io", "docker image registry server addr")
 	rootCmd.PersistentFlags().String("registry-username", "", "docker image registry username")
 	rootCmd.PersistentFlags().String("registry-password", "", "docker image registry password")
+	rootCmd.PersistentFlags().Int("reaper-interval", 600, "interval in seconds of removing orphaned job containers and workspaces (0 disables reaper)")
+	rootCmd.PersistentFlags().Int("reaper-diskthreshold", 80, "disk usage in percent above which docker images and build cache are pruned (0 disables pruning)")
+	rootCmd.PersistentFlags().String("executor-default", "docker", "executor used for jobs without executor in runs_on labels")
+	rootCmd.PersistentFlags().StringSlice("executor-enabled", []string{"docker"}, "enabled job executors (available options: docker, shell, kubernetes)")
+	rootCmd.PersistentFlags().StringSlice("executor-labels", []string{}, "additional labels matched against runs_on labels of jobs")
+	rootCmd.PersistentFlags().String("executor-kubernetes-kubeconfig", "", "path to kubeconfig file used by kubernetes executor (in-cluster config is used when empty)")
+	rootCmd.PersistentFlags().String("executor-kubernetes-namespace", "default", "namespace job pods are created in")
+	rootCmd.PersistentFlags().String("executor-kubernetes-serviceaccount", "", "service account of job pods")
+	rootCmd.PersistentFlags().String("executor-kubernetes-cloneimage", "alpine/git:latest", "image of init container cloning the repository into job pod")
+	rootCmd.PersistentFlags().String("executor-kubernetes-cpurequest", "", "CPU request of job container (e.g. 500m)")
+	rootCmd.PersistentFlags().String("executor-kubernetes-cpulimit", "", "CPU limit of job container (e.g. 2)")
+	rootCmd.PersistentFlags().String("executor-kubernetes-memoryrequest", "", "memory request of job container (e.g. 512Mi)")
+	rootCmd.PersistentFlags().String("executor-kubernetes-memorylimit", "", "memory limit of job container (e.g. 4Gi)")
+	rootCmd.PersistentFlags().Float64("resources-cpus", 0, "default CPU limit of job containers (0 is unlimited)")
+	rootCmd.PersistentFlags().String("resources-memory", "", "default memory limit of job containers (e.g. 2g, unlimited when empty)")
+	rootCmd.PersistentFlags().Int64("resources-pids", 0, "default processes limit of job containers (0 is unlimited)")
+	rootCmd.PersistentFlags().Float64("resources-max-cpus", 0, "maximum CPU limit of job containers (0 is unlimited)")
+	rootCmd.PersistentFlags().String("resources-max-memory", "", "maximum memory limit of job containers (e.g. 8g, unlimited when empty)")
+	rootCmd.PersistentFlags().Int64("resources-max-pids", 0, "maximum processes limit of job containers (0 is unlimited)")
+	rootCmd.PersistentFlags().Bool("network-isolate", true, "run each job in its own docker network")
+	rootCmd.PersistentFlags().Bool("network-disable-pr-egress", false, "disable outbound network access of pull request jobs")
+	rootCmd.PersistentFlags().String("git-mirrordir", "", "directory of repository mirrors job workspaces are cloned from, mirrors are disabled when empty")
 	rootCmd.PersistentFlags().String("logger-level", "info", "logging level (available options: debug, info, warn, error, panic, fatal)")
 	rootCmd.PersistentFlags().Bool("logger-stdout", true, "print logs to stdout")
 	rootCmd.PersistentFlags().String("logger-filename", "abstruse-worker.log", "log filename")
@@ -107,11 +141,36 @@ func initDefaults() {
 	viper.BindPFlag("server.addr", rootCmd.PersistentFlags().Lookup("server-addr"))
 	viper.BindPFlag("tls.cert", rootCmd.PersistentFlags().Lookup("tls-cert"))
 	viper.BindPFlag("tls.key", rootCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("tls.ca", rootCmd.PersistentFlags().Lookup("tls-ca"))
 	viper.BindPFlag("scheduler.maxparallel", rootCmd.PersistentFlags().Lookup("scheduler-maxparallel"))
+	viper.BindPFlag("scheduler.shutdowntimeout", rootCmd.PersistentFlags().Lookup("scheduler-shutdowntimeout"))
 	viper.BindPFlag("auth.jwtsecret", rootCmd.PersistentFlags().Lookup("auth-jwtsecret"))
+	viper.BindPFlag("auth.enrollmenttoken", rootCmd.PersistentFlags().Lookup("auth-enrollmenttoken"))
 	viper.BindPFlag("registry.addr", rootCmd.PersistentFlags().Lookup("registry-addr"))
 	viper.BindPFlag("registry.username", rootCmd.PersistentFlags().Lookup("registry-username"))
 	viper.BindPFlag("registry.password", rootCmd.PersistentFlags().Lookup("registry-password"))
+	viper.BindPFlag("reaper.interval", rootCmd.PersistentFlags().Lookup("reaper-interval"))
+	viper.BindPFlag("reaper.diskthreshold", rootCmd.PersistentFlags().Lookup("reaper-diskthreshold"))
+	viper.BindPFlag("executor.default", rootCmd.PersistentFlags().Lookup("executor-default"))
+	viper.BindPFlag("executor.enabled", rootCmd.PersistentFlags().Lookup("executor-enabled"))
+	viper.BindPFlag("executor.labels", rootCmd.PersistentFlags().Lookup("executor-labels"))
+	viper.BindPFlag("executor.kubernetes.kubeconfig", rootCmd.PersistentFlags().Lookup("executor-kubernetes-kubeconfig"))
+	viper.BindPFlag("executor.kubernetes.namespace", rootCmd.PersistentFlags().Lookup("executor-kubernetes-namespace"))
+	viper.BindPFlag("executor.kubernetes.serviceaccount", rootCmd.PersistentFlags().Lookup("executor-kubernetes-serviceaccount"))
+	viper.BindPFlag("executor.kubernetes.cloneimage", rootCmd.PersistentFlags().Lookup("executor-kubernetes-cloneimage"))
+	viper.BindPFlag("executor.kubernetes.cpurequest", rootCmd.PersistentFlags().Lookup("executor-kubernetes-cpurequest"))
+	viper.BindPFlag("executor.kubernetes.cpulimit", rootCmd.PersistentFlags().Lookup("executor-kubernetes-cpulimit"))
+	viper.BindPFlag("executor.kubernetes.memoryrequest", rootCmd.PersistentFlags().Lookup("executor-kubernetes-memoryrequest"))
+	viper.BindPFlag("executor.kubernetes.memorylimit", rootCmd.PersistentFlags().Lookup("executor-kubernetes-memorylimit"))
+	viper.BindPFlag("resources.cpus", rootCmd.PersistentFlags().Lookup("resources-cpus"))
+	viper.BindPFlag("resources.memory", rootCmd.PersistentFlags().Lookup("resources-memory"))
+	viper.BindPFlag("resources.pids", rootCmd.PersistentFlags().Lookup("resources-pids"))
+	viper.BindPFlag("resources.maxcpus", rootCmd.PersistentFlags().Lookup("resources-max-cpus"))
+	viper.BindPFlag("resources.maxmemory", rootCmd.PersistentFlags().Lookup("resources-max-memory"))
+	viper.BindPFlag("resources.maxpids", rootCmd.PersistentFlags().Lookup("resources-max-pids"))
+	viper.BindPFlag("network.isolate", rootCmd.PersistentFlags().Lookup("network-isolate"))
+	viper.BindPFlag("network.disablepregress", rootCmd.PersistentFlags().Lookup("network-disable-pr-egress"))
+	viper.BindPFlag("git.mirrordir", rootCmd.PersistentFlags().Lookup("git-mirrordir"))
 	viper.BindPFlag("logger.level", rootCmd.PersistentFlags().Lookup("logger-level"))
 	viper.BindPFlag("logger.stdout", rootCmd.PersistentFlags().Lookup("logger-stdout"))
 	viper.BindPFlag("logger.filename", rootCmd.PersistentFlags().Lookup("logger-filename"))
@@ -171,21 +230,17 @@ func newConfig() *config.Config {
 		cfg.TLS.Key = filepath.Join(filepath.Dir(cfgFileUsed), cfg.TLS.Key)
 	}
 
-	auth.Init(viper.GetString("auth.jwtsecret"))
-
-	cert, key := cfg.TLS.Cert, cfg.TLS.Key
-	if !strings.HasPrefix(cert, "/") {
-		cert = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), cert)
-	}
-	if !strings.HasPrefix(key, "/") {
-		key = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), key)
+	if !strings.HasPrefix(cfg.TLS.CA, "/") {
+		cfg.TLS.CA = filepath.Join(filepath.Dir(cfgFileUsed), cfg.TLS.CA)
 	}
 
-	if err := tlsutil.CheckAndGenerateCert(cert, key); err != nil {
-		fatal(err)
+	if cfg.Git.MirrorDir != "" && !strings.HasPrefix(cfg.Git.MirrorDir, "/") {
+		cfg.Git.MirrorDir = filepath.Join(filepath.Dir(cfgFileUsed), cfg.Git.MirrorDir)
 	}
 
-	docker.Init(cfg.Registry)
+	auth.Init(viper.GetString("auth.jwtsecret"))
+
+	docker.Init(cfg.ID, cfg.Registry)
 
 	return cfg
 }
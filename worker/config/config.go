@@ -10,6 +10,11 @@ type (
 		Scheduler *Scheduler `json:"scheduler"`
 		Auth      *Auth      `json:"auth"`
 		Registry  *Registry  `json:"registry"`
+		Reaper    *Reaper    `json:"reaper"`
+		Executor  *Executor  `json:"executor"`
+		Resources *Resources `json:"resources"`
+		Network   *Network   `json:"network"`
+		Git       *Git       `json:"git"`
 		Logger    *Logger    `json:"logger"`
 	}
 
@@ -22,6 +27,7 @@ type (
 	TLS struct {
 		Cert string `json:"cert"`
 		Key  string `json:"key"`
+		CA   string `json:"ca"`
 	}
 
 	// GRPC configuration.
@@ -31,12 +37,16 @@ type (
 
 	// Scheduler configuration.
 	Scheduler struct {
-		MaxParallel int `json:"maxparallel"`
+		MaxParallel     int `json:"maxparallel"`
+		ShutdownTimeout int `json:"shutdowntimeout"`
 	}
 
 	// Auth authentication config.
 	Auth struct {
-		JWTSecret string `json:"jwtsecret"`
+		JWTSecret       string `json:"jwtsecret"`
+		EnrollmentToken string `json:"enrollmenttoken"`
+		Token           string `json:"token"`
+		Secret          string `json:"secret"`
 	}
 
 	// Registry docker image registry configuration.
@@ -46,6 +56,54 @@ type (
 		Password string `json:"password"`
 	}
 
+	// Reaper orphaned containers and workspaces cleanup configuration.
+	Reaper struct {
+		Interval      int `json:"interval"`
+		DiskThreshold int `json:"diskthreshold"`
+	}
+
+	// Executor job executors configuration.
+	Executor struct {
+		Default    string      `json:"default"`
+		Enabled    []string    `json:"enabled"`
+		Labels     []string    `json:"labels"`
+		Kubernetes *Kubernetes `json:"kubernetes"`
+	}
+
+	// Resources job container resource limits configuration, defaults
+	// apply to jobs without limits and maximums clamp limits of all jobs.
+	Resources struct {
+		CPUs      float64 `json:"cpus"`
+		Memory    string  `json:"memory"`
+		Pids      int64   `json:"pids"`
+		MaxCPUs   float64 `json:"maxcpus"`
+		MaxMemory string  `json:"maxmemory"`
+		MaxPids   int64   `json:"maxpids"`
+	}
+
+	// Network job container network configuration.
+	Network struct {
+		Isolate         bool `json:"isolate"`
+		DisablePREgress bool `json:"disablepregress"`
+	}
+
+	// Git repository mirrors configuration.
+	Git struct {
+		MirrorDir string `json:"mirrordir"`
+	}
+
+	// Kubernetes pod executor configuration.
+	Kubernetes struct {
+		Kubeconfig     string `json:"kubeconfig"`
+		Namespace      string `json:"namespace"`
+		ServiceAccount string `json:"serviceaccount"`
+		CloneImage     string `json:"cloneimage"`
+		CPURequest     string `json:"cpurequest"`
+		CPULimit       string `json:"cpulimit"`
+		MemoryRequest  string `json:"memoryrequest"`
+		MemoryLimit    string `json:"memorylimit"`
+	}
+
 	// Logger config.
 	Logger struct {
 		Filename   string `json:"filename"`
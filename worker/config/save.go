@@ -0,0 +1,30 @@
+package config
+
+import (
+	"github.com/bleenco/abstruse/internal/auth"
+	"github.com/spf13/viper"
+)
+
+// Save persists config value into the config file.
+func Save(key string, value interface{}) error {
+	viper.Set(key, value)
+	return viper.WriteConfig()
+}
+
+// ServerToken returns token used to authenticate against abstruse
+// server. Token received on enrollment takes precedence, otherwise
+// token is signed with shared JWT secret.
+func (c *Config) ServerToken() (string, error) {
+	if c.Auth.Token != "" {
+		return c.Auth.Token, nil
+	}
+	return auth.JWT.CreateWorkerJWT(auth.WorkerClaims{ID: c.ID, Addr: c.GRPC.Addr})
+}
+
+// Secret returns secret used to verify requests from abstruse server.
+func (c *Config) Secret() []byte {
+	if c.Auth.Secret != "" {
+		return []byte(c.Auth.Secret)
+	}
+	return auth.JWTSecret
+}
@@ -3,181 +3,157 @@ package docker
 import (
 	"context"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 
-	api "github.com/bleenco/abstruse/pb"
 	"github.com/bleenco/abstruse/pkg/fs"
 	"github.com/bleenco/abstruse/pkg/lib"
-	"github.com/bleenco/abstruse/worker/cache"
-	"github.com/bleenco/abstruse/worker/config"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
-	"github.com/dustin/go-humanize"
 )
 
-// RunContainer runs container.
-func RunContainer(name, image string, job *api.Job, config *config.Config, env []string, dir string, logch chan<- []byte) error {
-	ctx := context.Background()
+// Container is a job container commands are executed in.
+type Container struct {
+	cli   *client.Client
+	ID    string
+	shell string
+	env   []string
+}
+
+// Resources are resource limits of the container, zero values are
+// unlimited.
+type Resources struct {
+	CPUs   float64
+	Memory int64
+	Pids   int64
+}
+
+// CreateContainer creates and starts job container with workspace
+// directory dir mounted at /build. Container is attached to network,
+// default bridge network is used when empty. Containers without bash
+// use sh.
+func CreateContainer(name, image, dir string, env, mounts []string, network string, resources Resources) (*Container, error) {
 	cli, err := client.NewClientWithOpts()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer close(logch)
-	var shell string
 
-	resp, err := createContainer(cli, name, image, dir, []string{"/bin/bash"}, env, job.GetMount())
+	shell := "bash"
+	resp, err := createContainer(cli, name, image, dir, []string{"/bin/bash"}, env, mounts, network, resources)
 	if err != nil {
-		logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-		return err
+		cli.Close()
+		return nil, err
 	}
-	if !isContainerRunning(cli, resp.ID) {
-		if err := startContainer(cli, resp.ID); err != nil {
-			resp, err = createContainer(cli, name, image, dir, []string{"/bin/sh"}, env, job.GetMount())
-			if err != nil {
-				logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-				return err
-			}
-			shell = "sh"
-		} else {
-			shell = "bash"
+	if err := startContainer(cli, resp.ID); err != nil {
+		resp, err = createContainer(cli, name, image, dir, []string{"/bin/sh"}, env, mounts, network, resources)
+		if err != nil {
+			cli.Close()
+			return nil, err
 		}
+		shell = "sh"
 	}
-	defer cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
-
-	logch <- []byte(yellow("==> Starting build...\r\n"))
 
-	exitCode := 0
-	containerID := resp.ID
-
-	job.Cache = lib.DeleteEmpty(job.GetCache())
+	return &Container{cli: cli, ID: resp.ID, shell: shell, env: env}, nil
+}
 
-	execCmd := func(command *api.Command) (string, error) {
-		cmd := strings.Split(command.GetCommand(), " ")
-		str := yellow("\r==> " + strings.Join(cmd, " ") + "\r\n")
-		logch <- []byte(str)
-		shcmd := []string{shell, "-ci", strings.Join(cmd, " ")}
-		conn, execID, err := exec(cli, containerID, shcmd, env)
-		if err != nil {
-			logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-			return "", err
-		}
-		for {
-			buf := make([]byte, 4096)
-			n, err := conn.Reader.Read(buf)
-			if err != nil {
-				conn.Close()
-				break
-			}
-			logch <- buf[:n]
-		}
-		return execID, nil
+// StartContainer creates and starts container which runs entrypoint of
+// the image with workspace directory dir mounted at /build and attached
+// to network.
+func StartContainer(name, image, dir string, env, mounts []string, network string, resources Resources) (*Container, error) {
+	cli, err := client.NewClientWithOpts()
+	if err != nil {
+		return nil, err
 	}
 
-	var commands []*api.Command
-	var failureCmd, successCmd *api.Command = nil, nil
-	for _, command := range job.GetCommands() {
-		if command.GetType() == api.Command_AfterFailure {
-			failureCmd = command
-			continue
-		}
-		if command.GetType() == api.Command_AfterSuccess {
-			successCmd = command
-			continue
-		}
-		commands = append(commands, command)
+	resp, err := createContainer(cli, name, image, dir, nil, env, mounts, network, resources)
+	if err != nil {
+		cli.Close()
+		return nil, err
 	}
-
-	var cacheIndex int
-	for i, command := range commands {
-		if command.GetType() == api.Command_Install || command.GetType() == api.Command_Script {
-			cacheIndex = i
-		}
+	if err := startContainer(cli, resp.ID); err != nil {
+		cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+		cli.Close()
+		return nil, err
 	}
 
-	for i, command := range commands {
-		if !isContainerRunning(cli, containerID) {
-			if err := startContainer(cli, containerID); err != nil {
-				logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-				return err
-			}
-		}
+	return &Container{cli: cli, ID: resp.ID, env: env}, nil
+}
 
-		// restore cache.
-		if i == 0 && len(job.GetCache()) > 0 {
-			logch <- []byte(yellow("\r==> Downloading and restoring cache... "))
-			cacheFile, err := cache.DownloadCache(config, job, dir)
-			if err != nil {
-				logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
-			} else {
-				logch <- []byte(yellow("done\r\n"))
-				os.RemoveAll(cacheFile)
-			}
+// Wait streams output of the container to logch until it exits and
+// returns its exit code.
+func (c *Container) Wait(logch chan<- []byte) (int, error) {
+	ctx := context.Background()
+	logs, err := c.cli.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		buf := make([]byte, 4096)
+		n, err := logs.Read(buf)
+		if n > 0 {
+			logch <- buf[:n]
 		}
-
-		execID, err := execCmd(command)
 		if err != nil {
-			logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-			return err
+			logs.Close()
+			break
 		}
+	}
 
-		inspect, err := cli.ContainerExecInspect(ctx, execID)
-		if err != nil {
-			logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-			return err
+	statusch, errch := c.cli.ContainerWait(ctx, c.ID, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusch:
+		return int(status.StatusCode), nil
+	case err := <-errch:
+		return 0, err
+	}
+}
+
+// Exec executes command in the container, streams its output to
+// logch and returns exit code of the command.
+func (c *Container) Exec(command string, logch chan<- []byte) (int, error) {
+	if !isContainerRunning(c.cli, c.ID) {
+		if err := startContainer(c.cli, c.ID); err != nil {
+			return 0, err
 		}
+	}
 
-		exitCode = inspect.ExitCode
-		if exitCode != 0 {
-			if failureCmd != nil {
-				logch <- []byte(red("==> Starting after_failure script...\n"))
-				if _, err := execCmd(failureCmd); err != nil {
-					logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
-				}
-			}
+	conn, execID, err := exec(c.cli, c.ID, []string{c.shell, "-ci", command}, c.env)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		buf := make([]byte, 4096)
+		n, err := conn.Reader.Read(buf)
+		if err != nil {
+			conn.Close()
 			break
 		}
+		logch <- buf[:n]
+	}
 
-		// save cache.
-		if i == cacheIndex && len(job.GetCache()) > 0 {
-			logch <- []byte(yellow("\r==> Saving cache... "))
-			cacheFile, err := cache.SaveCache(job, dir)
-
-			if err != nil {
-				logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
-			} else {
-				info, err := os.Stat(cacheFile)
-				if err != nil {
-					return err
-				}
-
-				logch <- []byte(yellow("done\r\n"))
-				logch <- []byte(yellow(fmt.Sprintf("\r==> Uploading cache (%s) to abstruse server... ", humanize.Bytes(uint64(info.Size())))))
-				if err := cache.UploadCache(config, cacheFile); err != nil {
-					logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
-				} else {
-					logch <- []byte(yellow("done\r\n"))
-				}
-
-				os.RemoveAll(cacheFile)
-			}
-		}
+	inspect, err := c.cli.ContainerExecInspect(context.Background(), execID)
+	if err != nil {
+		return 0, err
 	}
+	return inspect.ExitCode, nil
+}
 
-	logch <- []byte(genExitMessage(exitCode))
-	if exitCode == 0 {
-		if successCmd != nil {
-			logch <- []byte(green("\r==> Starting after_success script...\r\n"))
-			if _, err := execCmd(successCmd); err != nil {
-				logch <- []byte(red(err.Error()))
-			}
-		}
-		return nil
+// OOMKilled checks if any process in the container was killed for
+// running out of memory.
+func (c *Container) OOMKilled() bool {
+	data, err := inspectContainer(c.cli, c.ID)
+	if err != nil || data.State == nil {
+		return false
 	}
-	return fmt.Errorf("errored: %d", exitCode)
+	return data.State.OOMKilled
+}
+
+// Remove removes the container.
+func (c *Container) Remove() error {
+	defer c.cli.Close()
+	return c.cli.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{Force: true})
 }
 
 // StopContainer stops the container.
@@ -244,7 +220,7 @@ func startContainer(cli *client.Client, id string) error {
 }
 
 // CreateContainer creates new Docker container.
-func createContainer(cli *client.Client, name, image, dir string, cmd []string, env []string, mountdir []string) (container.ContainerCreateCreatedBody, error) {
+func createContainer(cli *client.Client, name, image, dir string, cmd []string, env []string, mountdir []string, network string, resources Resources) (container.ContainerCreateCreatedBody, error) {
 	if id, exists := ContainerExists(name); exists {
 		if err := cli.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true}); err != nil {
 			return container.ContainerCreateCreatedBody{}, err
@@ -256,13 +232,14 @@ func createContainer(cli *client.Client, name, image, dir string, cmd []string,
 	}
 	for i := range mountdir {
 		m := strings.Split(mountdir[i], ":")
-		if len(m) != 2 || !fs.Exists(m[0]) {
+		if len(m) < 2 || len(m) > 3 || !fs.Exists(m[0]) {
 			continue
 		}
 		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: m[0],
-			Target: m[1],
+			Type:     mount.TypeBind,
+			Source:   m[0],
+			Target:   m[1],
+			ReadOnly: len(m) == 3 && m[2] == "ro",
 		})
 	}
 
@@ -272,11 +249,28 @@ func createContainer(cli *client.Client, name, image, dir string, cmd []string,
 		Tty:        true,
 		Env:        env,
 		WorkingDir: "/build",
+		Labels:     map[string]string{workerLabel: workerID},
 	}, &container.HostConfig{
-		Mounts: mounts,
+		Mounts:      mounts,
+		NetworkMode: container.NetworkMode(network),
+		Resources:   hostResources(resources),
 	}, nil, nil, name)
 }
 
+// hostResources returns container resources with the limits, swap is
+// disabled when memory is limited.
+func hostResources(resources Resources) container.Resources {
+	r := container.Resources{
+		NanoCPUs:   int64(resources.CPUs * 1e9),
+		Memory:     resources.Memory,
+		MemorySwap: resources.Memory,
+	}
+	if resources.Pids > 0 {
+		r.PidsLimit = &resources.Pids
+	}
+	return r
+}
+
 // IsContainerRunning returns true if container is running.
 func isContainerRunning(cli *client.Client, id string) bool {
 	containers, err := listRunningContainers(cli)
@@ -1,11 +1,8 @@
 package docker
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -23,48 +20,32 @@ type ImageBuildOutput struct {
 	Stream string `json:"stream"`
 }
 
-// BuildImage builds the docker image.
-func BuildImage(tags []string, dockerFile string) (types.ImageBuildResponse, error) {
-	ctx := context.Background()
+// BuildImage builds the docker image from build context archive with
+// layer cache of the docker daemon. Build output is returned as stream
+// of JSON messages.
+func BuildImage(buildContext io.Reader, dockerfile string, tags []string, args map[string]*string) (io.ReadCloser, error) {
 	cli, err := client.NewClientWithOpts()
 	if err != nil {
-		panic(err)
-	}
-
-	tags = configureTags(tags)
-	dockerfile := []byte(dockerFile)
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
-
-	header := &tar.Header{
-		Name: "Dockerfile",
-		Size: int64(binary.Size(dockerfile)),
-	}
-
-	if err := tw.WriteHeader(header); err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer cli.Close()
 
-	if _, err := io.Copy(tw, bytes.NewReader(dockerfile)); err != nil {
-		panic(err)
-	}
-	tw.Close()
-
-	imageContext := bytes.NewReader(buf.Bytes())
-
-	return cli.ImageBuild(
-		ctx,
-		imageContext,
+	resp, err := cli.ImageBuild(
+		context.Background(),
+		buildContext,
 		types.ImageBuildOptions{
 			Tags:        tags,
-			Context:     imageContext,
-			Dockerfile:  "Dockerfile",
+			Dockerfile:  dockerfile,
+			BuildArgs:   args,
 			Remove:      true,
 			ForceRemove: true,
 			PullParent:  true,
-			NoCache:     true,
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
 }
 
 // PushImage pushes image to the registry.
@@ -72,17 +53,49 @@ func PushImage(tag string) (io.ReadCloser, error) {
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	tag = prependTag(tag)
+	defer cli.Close()
+	tag = RegistryTag(tag)
 
-	authConfig := types.AuthConfig{Username: cfg.Username, Password: cfg.Password}
+	authConfig := types.AuthConfig{Username: cfg.Username, Password: cfg.Password, ServerAddress: cfg.Addr}
 	authJSON, _ := json.Marshal(authConfig)
 	auth := base64.URLEncoding.EncodeToString(authJSON)
 
 	return cli.ImagePush(ctx, tag, types.ImagePushOptions{RegistryAuth: auth})
 }
 
+// StreamOutput writes output of build or push operation to logch. It
+// returns error reported by the operation.
+func StreamOutput(r io.Reader, logch chan<- []byte) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		var line string
+		switch {
+		case msg.Stream != "":
+			line = msg.Stream
+		case msg.Status != "" && msg.Progress == nil:
+			line = msg.Status + "\n"
+			if msg.ID != "" {
+				line = msg.ID + ": " + line
+			}
+		}
+		if line != "" {
+			logch <- []byte(strings.ReplaceAll(line, "\n", "\r\n"))
+		}
+	}
+}
+
 // PullImage pulls image from the registry.
 func PullImage(image string, config *config.Registry) error {
 	ctx := context.Background()
@@ -111,6 +124,17 @@ func PullImage(image string, config *config.Registry) error {
 	return nil
 }
 
+// ImageExists checks if image is available on the docker host.
+func ImageExists(image string) bool {
+	cli, err := client.NewClientWithOpts()
+	if err != nil {
+		return false
+	}
+	defer cli.Close()
+	_, _, err = cli.ImageInspectWithRaw(context.Background(), image)
+	return err == nil
+}
+
 // ListImages returns all images.
 func ListImages() []types.ImageSummary {
 	cli, err := client.NewClientWithOpts()
@@ -140,16 +164,15 @@ func StreamImageEvents(out chan<- jsonmessage.JSONMessage, events io.ReadCloser)
 	}
 }
 
-func configureTags(tags []string) []string {
-	for i, tag := range tags {
-		tags[i] = prependTag(tag)
+// RegistryTag prefixes tag with registry address unless tag already
+// contains registry host. Docker Hub tags are left as they are.
+func RegistryTag(tag string) string {
+	addr := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(cfg.Addr, "https://"), "http://"), "/")
+	if addr == "" || strings.HasSuffix(addr, "docker.io") {
+		return tag
 	}
-	return tags
-}
-
-func prependTag(tag string) string {
-	if !strings.HasPrefix(tag, cfg.Addr) {
-		tag = path.Clean(path.Join(cfg.Addr, tag))
+	if i := strings.Index(tag, "/"); i > 0 && (strings.ContainsAny(tag[:i], ".:") || tag[:i] == "localhost") {
+		return tag
 	}
-	return tag
+	return path.Join(addr, tag)
 }
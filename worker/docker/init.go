@@ -3,10 +3,12 @@ package docker
 import "github.com/bleenco/abstruse/worker/config"
 
 var (
-	cfg *config.Registry
+	cfg      *config.Registry
+	workerID string
 )
 
 // Init initializes global variables
-func Init(config *config.Registry) {
+func Init(id string, config *config.Registry) {
+	workerID = id
 	cfg = config
 }
@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	// networkLabel is a label of job networks.
+	networkLabel = "com.abstruse.job"
+
+	// workerLabel is a label of job containers and networks holding ID
+	// of the worker which created them.
+	workerLabel = "com.abstruse.worker"
+)
+
+// CreateNetwork creates bridge network job containers are attached to,
+// containers on different networks cannot reach each other. Internal
+// network has no outbound access. Existing network with the same name
+// left behind by previous run of the job is removed first.
+func CreateNetwork(name string, internal bool) error {
+	cli, err := client.NewClientWithOpts()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+	if _, err := cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{}); err == nil {
+		if err := cli.NetworkRemove(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	_, err = cli.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Internal:       internal,
+		Labels:         map[string]string{networkLabel: name, workerLabel: workerID},
+	})
+	return err
+}
+
+// RemoveNetwork removes the network.
+func RemoveNetwork(name string) error {
+	cli, err := client.NewClientWithOpts()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	return cli.NetworkRemove(context.Background(), name)
+}
@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bleenco/abstruse/pkg/fs"
+	"github.com/bleenco/abstruse/pkg/stats"
+	"github.com/bleenco/abstruse/worker/config"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+// JobPrefix is a name prefix of job containers and workspaces.
+const JobPrefix = "abstruse-job-"
+
+// TempDir returns directory holding temporary workspaces and output
+// buffers of jobs. Each worker gets its own directory, so workers
+// sharing temp directory never remove each other's files.
+func TempDir() (string, error) {
+	name := "abstruse-worker-" + strings.ReplaceAll(workerID, string(filepath.Separator), "_")
+	dir := filepath.Join(fs.TempRoot(), name)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// WorkspaceDir creates temporary workspace directory for the job. Each
+// run of the job gets new directory with random suffix, so runs of the
+// same job started again never share or remove each other's workspace.
+func WorkspaceDir(id uint64) (string, error) {
+	root, err := TempDir()
+	if err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(root, fmt.Sprintf("%s%d-", JobPrefix, id))
+}
+
+// Reaper periodically removes job containers, networks and workspaces
+// which are left behind by jobs worker does not track anymore and
+// prunes images and build cache when disk usage exceeds the threshold.
+type Reaper struct {
+	config  *config.Reaper
+	tracked func(uint64) bool
+	logger  *zap.SugaredLogger
+	freed   uint64
+}
+
+// NewReaper returns new Reaper instance. Function tracked reports
+// whether job with specified ID is running on the worker.
+func NewReaper(config *config.Reaper, tracked func(uint64) bool, logger *zap.Logger) *Reaper {
+	return &Reaper{
+		config:  config,
+		tracked: tracked,
+		logger:  logger.With(zap.String("type", "reaper")).Sugar(),
+	}
+}
+
+// Run starts reaper loop.
+func (r *Reaper) Run() {
+	if r.config.Interval <= 0 {
+		return
+	}
+
+	r.reap()
+	ticker := time.NewTicker(time.Duration(r.config.Interval) * time.Second)
+	for range ticker.C {
+		r.reap()
+	}
+}
+
+// Freed returns total amount of disk space in bytes freed by reaper.
+func (r *Reaper) Freed() uint64 {
+	return atomic.LoadUint64(&r.freed)
+}
+
+func (r *Reaper) reap() {
+	cli, err := client.NewClientWithOpts()
+	if err != nil {
+		r.logger.Errorf("error creating docker client: %v", err)
+		return
+	}
+	defer cli.Close()
+
+	var freed uint64
+	freed += r.reapContainers(cli)
+	r.reapNetworks(cli)
+	freed += r.reapWorkspaces()
+	freed += r.prune(cli)
+
+	if freed > 0 {
+		atomic.AddUint64(&r.freed, freed)
+		r.logger.Infof("reaper freed %s", humanize.Bytes(freed))
+	}
+}
+
+// reapContainers removes job containers of untracked jobs created by
+// the worker, containers of other workers sharing docker daemon are
+// skipped. Containers are listed before checking tracked jobs so that
+// containers of jobs started in between are never removed.
+func (r *Reaper) reapContainers(cli *client.Client) uint64 {
+	ctx := context.Background()
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:  true,
+		Size: true,
+		Filters: filters.NewArgs(
+			filters.Arg("name", JobPrefix),
+			filters.Arg("label", workerLabel+"="+workerID),
+		),
+	})
+	if err != nil {
+		r.logger.Errorf("error listing containers: %v", err)
+		return 0
+	}
+
+	var freed uint64
+	for _, container := range containers {
+		for _, name := range container.Names {
+			id, ok := parseJobID(strings.TrimPrefix(name, "/"))
+			if !ok || r.tracked(id) {
+				continue
+			}
+			if err := cli.ContainerRemove(ctx, container.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+				r.logger.Errorf("error removing orphaned container %s: %v", name, err)
+				break
+			}
+			r.logger.Infof("removed orphaned container %s", name)
+			freed += uint64(container.SizeRw)
+			break
+		}
+	}
+
+	return freed
+}
+
+// reapNetworks removes job networks of untracked jobs created by the
+// worker, containers attached to them are removed by reapContainers
+// beforehand.
+func (r *Reaper) reapNetworks(cli *client.Client) {
+	ctx := context.Background()
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", networkLabel),
+			filters.Arg("label", workerLabel+"="+workerID),
+		),
+	})
+	if err != nil {
+		r.logger.Errorf("error listing networks: %v", err)
+		return
+	}
+
+	for _, network := range networks {
+		id, ok := parseJobID(network.Name)
+		if !ok || r.tracked(id) {
+			continue
+		}
+		if err := cli.NetworkRemove(ctx, network.ID); err != nil {
+			r.logger.Errorf("error removing orphaned network %s: %v", network.Name, err)
+			continue
+		}
+		r.logger.Infof("removed orphaned network %s", network.Name)
+	}
+}
+
+// reapWorkspaces removes temporary workspaces and output buffers
+// of untracked jobs of the worker.
+func (r *Reaper) reapWorkspaces() uint64 {
+	root, err := TempDir()
+	if err != nil {
+		r.logger.Errorf("error creating %s: %v", root, err)
+		return 0
+	}
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		r.logger.Errorf("error reading %s: %v", root, err)
+		return 0
+	}
+
+	var freed uint64
+	for _, file := range files {
+		id, ok := parseJobID(file.Name())
+		if !ok || r.tracked(id) {
+			continue
+		}
+		path := filepath.Join(root, file.Name())
+		size := dirSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			r.logger.Errorf("error removing orphaned workspace %s: %v", path, err)
+			continue
+		}
+		r.logger.Infof("removed orphaned workspace %s", path)
+		freed += size
+	}
+
+	return freed
+}
+
+// prune removes dangling images and build cache when disk usage is
+// above the threshold. If that is not enough all unused images are
+// removed as well.
+func (r *Reaper) prune(cli *client.Client) uint64 {
+	if r.config.DiskThreshold <= 0 || r.diskUsage(cli) < r.config.DiskThreshold {
+		return 0
+	}
+
+	ctx := context.Background()
+	var freed uint64
+
+	r.logger.Infof("disk usage above %d%%, pruning dangling images and build cache", r.config.DiskThreshold)
+	if report, err := cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "true"))); err != nil {
+		r.logger.Errorf("error pruning images: %v", err)
+	} else {
+		freed += report.SpaceReclaimed
+	}
+	if report, err := cli.BuildCachePrune(ctx, types.BuildCachePruneOptions{All: true}); err != nil {
+		r.logger.Errorf("error pruning build cache: %v", err)
+	} else {
+		freed += report.SpaceReclaimed
+	}
+
+	if r.diskUsage(cli) < r.config.DiskThreshold {
+		return freed
+	}
+
+	r.logger.Infof("disk usage still above %d%%, pruning unused images", r.config.DiskThreshold)
+	if report, err := cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "false"))); err != nil {
+		r.logger.Errorf("error pruning images: %v", err)
+	} else {
+		freed += report.SpaceReclaimed
+	}
+
+	return freed
+}
+
+// diskUsage returns disk usage in percent of the filesystem holding
+// docker data, falls back to temp directory when it is not accessible.
+func (r *Reaper) diskUsage(cli *client.Client) int {
+	path := fs.TempRoot()
+	if info, err := cli.Info(context.Background()); err == nil && fs.Exists(info.DockerRootDir) {
+		path = info.DockerRootDir
+	}
+	usage, err := stats.GetDiskUsage(path)
+	if err != nil {
+		r.logger.Errorf("error reading disk usage of %s: %v", path, err)
+		return 0
+	}
+	return usage
+}
+
+// parseJobID parses job ID from container, network or workspace name.
+func parseJobID(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, JobPrefix) {
+		return 0, false
+	}
+	name = strings.TrimPrefix(name, JobPrefix)
+	if i := strings.Index(name, "-"); i != -1 {
+		name = name[:i]
+	}
+	id, err := strconv.ParseUint(name, 10, 64)
+	return id, err == nil
+}
+
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
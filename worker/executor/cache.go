@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"text/template"
+	"time"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/worker/cache"
+	"github.com/bleenco/abstruse/worker/config"
+	"github.com/dustin/go-humanize"
+)
+
+// jobCache is cache of the job with keys computed in the workspace.
+type jobCache struct {
+	paths       []string
+	key         string
+	restoreKeys []string
+	keyed       bool
+	hit         bool
+}
+
+// cacheKeys computes keys of the caches. Key is namespaced by repository
+// and scope of the job, cache without key is stored per branch. Caches
+// with invalid key templates are left out.
+func cacheKeys(ctx context.Context, e Executor, job *pb.Job, dir string, logch chan<- []byte) []*jobCache {
+	var caches []*jobCache
+	for _, c := range job.GetCaches() {
+		prefix := fmt.Sprintf("%s/%s/", job.GetRepoName(), c.GetScope())
+		jc := &jobCache{paths: c.GetPaths(), key: prefix + job.GetBranch()}
+		if c.GetKey() != "" {
+			key, err := cacheKey(ctx, e, job, c.GetKey(), dir)
+			if err != nil {
+				logch <- []byte(yellow(fmt.Sprintf("==> Cache key %s: %s\r\n", c.GetKey(), err.Error())))
+				continue
+			}
+			jc.key, jc.keyed = prefix+key, true
+			for _, restoreKey := range c.GetRestoreKeys() {
+				key, err := cacheKey(ctx, e, job, restoreKey, dir)
+				if err != nil {
+					logch <- []byte(yellow(fmt.Sprintf("==> Cache restore key %s: %s\r\n", restoreKey, err.Error())))
+					continue
+				}
+				jc.restoreKeys = append(jc.restoreKeys, prefix+key)
+			}
+		}
+		caches = append(caches, jc)
+	}
+	return caches
+}
+
+// cacheKey evaluates key template in the workspace directory dir.
+// Template functions are checksum of files, env, branch, os, arch and
+// epoch.
+func cacheKey(ctx context.Context, e Executor, job *pb.Job, text, dir string) (string, error) {
+	funcs := template.FuncMap{
+		"checksum": func(paths ...string) (string, error) {
+			return checksum(ctx, e, dir, paths)
+		},
+		"env": func(name string) string {
+			for _, env := range job.GetEnv() {
+				if env.GetKey() == name {
+					return env.GetValue()
+				}
+			}
+			return ""
+		},
+		"branch": job.GetBranch,
+		"os":     func() string { return runtime.GOOS },
+		"arch":   func() string { return runtime.GOARCH },
+		"epoch":  func() string { return strconv.FormatInt(time.Now().Unix(), 10) },
+	}
+	tmpl, err := template.New("key").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// checksum returns hex encoded SHA-256 hash of contents of files at
+// paths relative to the workspace, files are collected from the
+// environment first.
+func checksum(ctx context.Context, e Executor, dir string, paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", fmt.Errorf("checksum: no files specified")
+	}
+	for _, path := range paths {
+		if !inside(dir, filepath.Join(dir, path)) {
+			return "", fmt.Errorf("checksum: %s is outside of the workspace", path)
+		}
+	}
+	if err := e.Collect(ctx, paths); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, path := range paths {
+		file, err := os.Open(filepath.Join(dir, path))
+		if err != nil {
+			return "", fmt.Errorf("checksum: %v", err)
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restoreCache downloads caches from abstruse server and restores them
+// into the environment. Cache with key falls back to the most recent
+// cache matching one of its restore keys.
+func restoreCache(ctx context.Context, e Executor, job *pb.Job, caches []*jobCache, config *config.Config, dir string, logch chan<- []byte) {
+	for _, c := range caches {
+		logch <- []byte(yellow(fmt.Sprintf("==> Restoring cache %s... ", c.key)))
+		key, err := cache.DownloadCache(config, job.GetJobToken(), c.key, c.restoreKeys, dir)
+		if err != nil {
+			logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
+			continue
+		}
+		if err := e.Restore(ctx, c.paths); err != nil {
+			logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
+			continue
+		}
+		c.hit = key == c.key
+		if c.hit {
+			logch <- []byte(yellow("done\r\n"))
+		} else {
+			logch <- []byte(yellow(fmt.Sprintf("restored %s\r\n", key)))
+		}
+	}
+}
+
+// saveCache uploads caches to abstruse server, cache with key is
+// uploaded only when it does not exist yet. Caches without key are
+// uploaded every time, as their contents change without the key.
+func saveCache(ctx context.Context, e Executor, job *pb.Job, caches []*jobCache, config *config.Config, dir string, logch chan<- []byte) {
+	for _, c := range caches {
+		if c.keyed {
+			if c.hit {
+				continue
+			}
+			if exists, err := cache.CacheExists(config, job.GetJobToken(), c.key); err == nil && exists {
+				continue
+			}
+		}
+		uploadCache(ctx, e, job, c, config, dir, logch)
+	}
+}
+
+// uploadCache collects cached paths from the environment and uploads
+// them to abstruse server while they are being archived.
+func uploadCache(ctx context.Context, e Executor, job *pb.Job, c *jobCache, config *config.Config, dir string, logch chan<- []byte) {
+	logch <- []byte(yellow(fmt.Sprintf("\r==> Saving cache %s... ", c.key)))
+	if err := e.Collect(ctx, c.paths); err != nil {
+		logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
+		return
+	}
+
+	size, err := cache.UploadCache(config, job.GetJobToken(), c.key, c.paths, dir)
+	if err != nil {
+		logch <- []byte(yellow(fmt.Sprintf("%s\r\n", err.Error())))
+		return
+	}
+	logch <- []byte(yellow(fmt.Sprintf("done (%s)\r\n", humanize.Bytes(uint64(size)))))
+}
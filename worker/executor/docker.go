@@ -0,0 +1,307 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/worker/config"
+	"github.com/bleenco/abstruse/worker/docker"
+	"github.com/bleenco/abstruse/worker/git"
+)
+
+// dockerExecutor runs commands in Docker container with workspace
+// directory mounted as a volume.
+type dockerExecutor struct {
+	mu        sync.Mutex
+	name      string
+	image     string
+	build     *pb.DockerBuild
+	env       []string
+	mounts    []string
+	registry  *config.Registry
+	resources docker.Resources
+	network   string
+	mirrorDir string
+	mirror    string
+	internal  bool
+	owner     bool
+	created   bool
+	dir       string
+	container *docker.Container
+	steps     []*dockerExecutor
+	plugins   map[*docker.Container]bool
+	stopped   bool
+}
+
+// newDockerExecutor returns executor of the job. Containers of the job
+// are attached to network of the job when networks are isolated, pull
+// request jobs have no outbound access when it is disabled. Objects of
+// the repository mirror the workspace is cloned from are mounted when
+// mirrors are enabled.
+func newDockerExecutor(name string, job *pb.Job, env []string, registry *config.Registry, network *config.Network, mirrors *config.Git, resources docker.Resources) *dockerExecutor {
+	e := &dockerExecutor{
+		name:      name,
+		image:     job.GetImage(),
+		build:     job.GetImageBuild(),
+		env:       env,
+		mounts:    job.GetMount(),
+		registry:  registry,
+		resources: resources,
+	}
+	if mirrors != nil && mirrors.MirrorDir != "" {
+		e.mirrorDir = mirrors.MirrorDir
+		e.mirror = git.MirrorObjects(mirrors.MirrorDir, git.JobOptions(job).URL)
+	}
+	if network != nil {
+		e.internal = network.DisablePREgress && job.GetPullRequest()
+		if network.Isolate || e.internal {
+			e.network, e.owner = name, true
+		}
+	}
+	return e
+}
+
+// Prepare creates network of the job and starts the job container, jobs
+// without image do not run commands in a container, e.g. docker image
+// build job. Image built from Dockerfile in the workspace is built
+// before the container starts.
+func (e *dockerExecutor) Prepare(ctx context.Context, dir string, logch chan<- []byte) error {
+	if e.owner {
+		if e.internal {
+			logch <- []byte(yellow("==> Outbound network access is disabled for pull request\r\n"))
+		}
+		if err := docker.CreateNetwork(e.network, e.internal); err != nil {
+			return fmt.Errorf("creating network %s failed: %v", e.network, err)
+		}
+		e.mu.Lock()
+		stopped := e.stopped
+		e.created = !stopped
+		e.mu.Unlock()
+		if stopped {
+			docker.RemoveNetwork(e.network)
+			return ErrStopped
+		}
+	}
+
+	if m := alternateMount(e.mirrorDir, e.mirror); m != "" && !lib.Include(e.mounts, m) {
+		e.mounts = append(e.mounts, m)
+	}
+
+	switch {
+	case e.build != nil:
+		image, err := jobImage(dir, e.build, logch)
+		if err != nil {
+			return err
+		}
+		e.image = image
+	case e.image == "":
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.dir = dir
+		return nil
+	default:
+		logch <- []byte(yellow(fmt.Sprintf("==> Pulling image %s... ", e.image)))
+		if err := docker.PullImage(e.image, e.registry); err != nil {
+			logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
+		} else {
+			logch <- []byte(yellow("done\r\n"))
+		}
+	}
+
+	e.mu.Lock()
+	stopped := e.stopped
+	e.dir = dir
+	e.mu.Unlock()
+	if stopped {
+		return ErrStopped
+	}
+
+	logch <- []byte(yellow(fmt.Sprintf("==> Starting container %s...\r\n", e.name)))
+	if limits := formatResources(e.resources); limits != "" {
+		logch <- []byte(yellow(fmt.Sprintf("==> Resource limits: %s\r\n", limits)))
+	}
+	container, err := docker.CreateContainer(e.name, e.image, dir, e.env, e.mounts, e.network, e.resources)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		container.Remove()
+		return ErrStopped
+	}
+	e.container = container
+	return nil
+}
+
+func (e *dockerExecutor) Run(ctx context.Context, command string, logch chan<- []byte) (int, error) {
+	e.mu.Lock()
+	container, stopped := e.container, e.stopped
+	e.mu.Unlock()
+	if stopped {
+		return 0, ErrStopped
+	}
+	if container == nil {
+		return 0, fmt.Errorf("image not specified")
+	}
+
+	// OOM state is kept by the container for its lifetime, so command
+	// is OOM killed only when the state changed during the command or
+	// command was killed with SIGKILL after an earlier OOM kill.
+	oomKilled := container.OOMKilled()
+	code, err := container.Exec(command, logch)
+	if err == nil && code != 0 && container.OOMKilled() && (!oomKilled || code == 137) {
+		return code, ErrOOMKilled
+	}
+	return code, err
+}
+
+// WriteFile writes file to workspace directory mounted from the worker.
+func (e *dockerExecutor) WriteFile(ctx context.Context, path string, data []byte) error {
+	return writeFile(e.dir, path, data)
+}
+
+// Collect is a no-op, workspace is mounted from the worker.
+func (e *dockerExecutor) Collect(ctx context.Context, paths []string) error {
+	return nil
+}
+
+// Restore is a no-op, workspace is mounted from the worker.
+func (e *dockerExecutor) Restore(ctx context.Context, paths []string) error {
+	return nil
+}
+
+// WithImage returns executor which runs commands in another container
+// with the same workspace directory mounted and the same network.
+func (e *dockerExecutor) WithImage(id, image string) Executor {
+	step := &dockerExecutor{
+		name:      fmt.Sprintf("%s-%s", e.name, id),
+		image:     image,
+		env:       e.env,
+		mounts:    e.mounts,
+		registry:  e.registry,
+		resources: e.resources,
+		network:   e.network,
+		mirrorDir: e.mirrorDir,
+		mirror:    e.mirror,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	step.stopped = e.stopped
+	e.steps = append(e.steps, step)
+	return step
+}
+
+// RunPlugin runs entrypoint of plugin image in a container with the
+// workspace directory mounted and the same network and waits for it to
+// exit.
+func (e *dockerExecutor) RunPlugin(ctx context.Context, id, image string, env []string, logch chan<- []byte) (int, error) {
+	logch <- []byte(yellow(fmt.Sprintf("==> Pulling plugin image %s... ", image)))
+	if err := docker.PullImage(image, e.registry); err != nil {
+		logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
+	} else {
+		logch <- []byte(yellow("done\r\n"))
+	}
+
+	e.mu.Lock()
+	dir, stopped := e.dir, e.stopped
+	e.mu.Unlock()
+	if stopped {
+		return 0, ErrStopped
+	}
+
+	container, err := docker.StartContainer(fmt.Sprintf("%s-%s", e.name, id), image, dir, append(e.env, env...), e.mounts, e.network, e.resources)
+	if err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		container.Remove()
+		return 0, ErrStopped
+	}
+	if e.plugins == nil {
+		e.plugins = make(map[*docker.Container]bool)
+	}
+	e.plugins[container] = true
+	e.mu.Unlock()
+
+	// plugin container runs only once, its OOM state belongs to this run.
+	code, err := container.Wait(logch)
+	if err == nil && code != 0 && container.OOMKilled() {
+		err = ErrOOMKilled
+	}
+
+	e.mu.Lock()
+	removed := !e.plugins[container]
+	delete(e.plugins, container)
+	stopped = e.stopped
+	e.mu.Unlock()
+	if !removed {
+		container.Remove()
+	}
+	if stopped {
+		return 0, ErrStopped
+	}
+	return code, err
+}
+
+// Cleanup removes containers of the job and then its network.
+func (e *dockerExecutor) Cleanup(ctx context.Context) error {
+	e.mu.Lock()
+	container, steps, plugins, created := e.container, e.steps, e.plugins, e.created
+	e.container, e.steps, e.plugins, e.created, e.stopped = nil, nil, nil, false, true
+	e.mu.Unlock()
+
+	for _, step := range steps {
+		step.Cleanup(ctx)
+	}
+	for plugin := range plugins {
+		plugin.Remove()
+	}
+
+	var err error
+	if container != nil {
+		err = container.Remove()
+	}
+	if created {
+		if nerr := docker.RemoveNetwork(e.network); nerr != nil && err == nil {
+			err = nerr
+		}
+	}
+	return err
+}
+
+// alternateMount returns read-only mount of objects directory of the
+// repository mirror at path, so git can read objects the workspace
+// borrows from the mirror in containers of the job. Path is computed by
+// the worker, alternates in the workspace can be written by the job and
+// are not read. Paths outside of mirror directory dir are rejected.
+func alternateMount(dir, path string) string {
+	if dir == "" || path == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return ""
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:ro", path, path)
+}
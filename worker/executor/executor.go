@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/worker/config"
+)
+
+// Available executors.
+const (
+	Docker     = "docker"
+	Shell      = "shell"
+	Kubernetes = "kubernetes"
+)
+
+// ErrStopped is returned when executor has been cleaned up while
+// the job was still running.
+var ErrStopped = errors.New("executor stopped")
+
+// Executor defines environment job commands are executed in.
+type Executor interface {
+	// Prepare creates environment for the job with workspace
+	// directory dir available in it.
+	Prepare(ctx context.Context, dir string, logch chan<- []byte) error
+
+	// Run runs command in the environment, streams its output to
+	// logch and returns exit code of the command.
+	Run(ctx context.Context, command string, logch chan<- []byte) (int, error)
+
+	// WriteFile writes file at path relative to the workspace in the
+	// environment.
+	WriteFile(ctx context.Context, path string, data []byte) error
+
+	// Collect copies files and directories at paths relative to the
+	// workspace from the environment to workspace directory on worker.
+	Collect(ctx context.Context, paths []string) error
+
+	// Restore copies files and directories at paths relative to the
+	// workspace from workspace directory on worker to the environment.
+	Restore(ctx context.Context, paths []string) error
+
+	// Cleanup stops running commands and removes the environment.
+	// It is safe to call it multiple times and concurrently with Run.
+	Cleanup(ctx context.Context) error
+}
+
+// Cloner is implemented by executors which clone the repository into
+// the environment themselves, workspace directory on the worker is not
+// cloned for them.
+type Cloner interface {
+	ClonesRepository() bool
+}
+
+// Imager is implemented by executors which can run a step in another
+// image with the workspace of the job shared. Returned executor is
+// cleaned up together with the executor of the job.
+type Imager interface {
+	WithImage(id, image string) Executor
+}
+
+// PluginRunner is implemented by executors which can run plugin steps,
+// which are images run with their own entrypoint and the workspace of
+// the job shared.
+type PluginRunner interface {
+	RunPlugin(ctx context.Context, id, image string, env []string, logch chan<- []byte) (int, error)
+}
+
+// New returns executor for the job. Executor is selected from runs_on
+// labels of the job, default executor is used when none of the labels
+// names an executor.
+func New(name string, job *pb.Job, env []string, config *config.Config) (Executor, error) {
+	kind, err := Select(job.GetRunsOn(), config.Executor)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case Docker:
+		resources, err := resources(job, config.Resources)
+		if err != nil {
+			return nil, err
+		}
+		return newDockerExecutor(name, job, env, config.Registry, config.Network, config.Git, resources), nil
+	case Shell:
+		return newShellExecutor(env), nil
+	case Kubernetes:
+		if job.GetImageBuild() != nil {
+			return nil, fmt.Errorf("building job image from Dockerfile is not supported by %s executor", kind)
+		}
+		client, exec, err := newKubernetesClient(config.Executor.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		return newKubernetesExecutor(name, job, env, config.Executor.Kubernetes, client, exec), nil
+	default:
+		return nil, fmt.Errorf("unknown executor: %s", kind)
+	}
+}
+
+// writeFile writes file at path relative to workspace directory dir.
+func writeFile(dir, path string, data []byte) error {
+	path = filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Select returns name of the executor for runs_on labels. It returns
+// error when worker does not provide all of the labels.
+func Select(labels []string, config *config.Executor) (string, error) {
+	provided := Labels(config)
+	for _, label := range labels {
+		if !lib.Include(provided, label) {
+			return "", fmt.Errorf("runs_on label %s is not provided by this worker", label)
+		}
+	}
+	for _, label := range labels {
+		if lib.Include(config.Enabled, label) {
+			return label, nil
+		}
+	}
+	if !lib.Include(config.Enabled, config.Default) {
+		return "", fmt.Errorf("default executor %s is not enabled", config.Default)
+	}
+	return config.Default, nil
+}
+
+// Labels returns labels worker provides, which are names of enabled
+// executors and additional configured labels.
+func Labels(config *config.Executor) []string {
+	var labels []string
+	for _, label := range append(config.Enabled, config.Labels...) {
+		if label != "" && !lib.Include(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/worker/docker"
+)
+
+// runDockerBuild builds docker image from the workspace directory dir
+// on the worker and pushes it to the registry when enabled. Build output
+// is streamed to logch. It returns exit code 1 when build or push fails.
+func runDockerBuild(ctx context.Context, e Executor, build *pb.DockerBuild, dir string, logch chan<- []byte) (int, error) {
+	contextDir, dockerfile, err := buildPaths(dir, build)
+	if err != nil {
+		return 0, err
+	}
+
+	// copy build context from the environment to the worker.
+	rel, _ := filepath.Rel(dir, contextDir)
+	if err := e.Collect(ctx, []string{rel}); err != nil {
+		return 0, err
+	}
+
+	var tags []string
+	for _, tag := range build.GetTags() {
+		tags = append(tags, docker.RegistryTag(tag))
+	}
+	logch <- []byte(yellow(fmt.Sprintf("\r==> Building image %s...\r\n", strings.Join(tags, ", "))))
+	if err := buildImage(contextDir, dockerfile, tags, build.GetBuildArgs(), logch); err != nil {
+		logch <- []byte(red(fmt.Sprintf("\r==> Building image failed: %s\r\n", err.Error())))
+		return 1, nil
+	}
+
+	if !build.GetPush() {
+		return 0, nil
+	}
+	for _, tag := range build.GetTags() {
+		logch <- []byte(yellow(fmt.Sprintf("\r==> Pushing image %s...\r\n", tag)))
+		out, err := docker.PushImage(tag)
+		if err == nil {
+			err = docker.StreamOutput(out, logch)
+			out.Close()
+		}
+		if err != nil {
+			logch <- []byte(red(fmt.Sprintf("\r==> Pushing image failed: %s\r\n", err.Error())))
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// jobImage returns job image built from Dockerfile in the workspace
+// directory dir. Image is tagged by hash of the build context which
+// includes the Dockerfile, so it is built only when any of them changes
+// and reused by jobs of all builds on the worker otherwise.
+func jobImage(dir string, build *pb.DockerBuild, logch chan<- []byte) (string, error) {
+	contextDir, dockerfile, err := buildPaths(dir, build)
+	if err != nil {
+		return "", err
+	}
+	exclude, err := dockerignore(contextDir)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashDir(contextDir, append(exclude, scriptDir))
+	if err != nil {
+		return "", err
+	}
+	image := fmt.Sprintf("%s:%s", imagePrefix, hash[:16])
+
+	if docker.ImageExists(image) {
+		logch <- []byte(yellow(fmt.Sprintf("==> Using image %s built from %s\r\n", image, dockerfile)))
+		return image, nil
+	}
+	logch <- []byte(yellow(fmt.Sprintf("==> Building image %s from %s...\r\n", image, dockerfile)))
+	if err := buildImage(contextDir, dockerfile, []string{image}, nil, logch); err != nil {
+		return "", fmt.Errorf("building image failed: %v", err)
+	}
+	return image, nil
+}
+
+// imagePrefix is name of job images built from Dockerfile.
+const imagePrefix = "abstruse-image"
+
+// buildImage builds image from build context directory contextDir with
+// Dockerfile at path relative to it. Files matching .dockerignore
+// patterns and abstruse scripts are left out of the build context.
+func buildImage(contextDir, dockerfile string, tags, buildArgs []string, logch chan<- []byte) error {
+	exclude, err := dockerignore(contextDir)
+	if err != nil {
+		return err
+	}
+	exclude = append(exclude, scriptDir)
+
+	args := make(map[string]*string)
+	for _, arg := range buildArgs {
+		if i := strings.Index(arg, "="); i > 0 {
+			value := arg[i+1:]
+			args[arg[:i]] = &value
+		}
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(createTar(w, contextDir, exclude))
+	}()
+	defer r.Close()
+
+	out, err := docker.BuildImage(r, dockerfile, tags, args)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return docker.StreamOutput(out, logch)
+}
+
+// buildPaths returns build context directory and path of the Dockerfile
+// relative to it. Dockerfile path is relative to the workspace directory
+// dir, by default it is Dockerfile in the build context. Both have to be
+// inside the workspace and Dockerfile inside the build context.
+func buildPaths(dir string, build *pb.DockerBuild) (string, string, error) {
+	contextDir := filepath.Join(dir, build.GetContext())
+	if !inside(dir, contextDir) {
+		return "", "", fmt.Errorf("build context %s is outside of the workspace", build.GetContext())
+	}
+	name := build.GetDockerfile()
+	if name == "" {
+		name = filepath.Join(build.GetContext(), "Dockerfile")
+	}
+	dockerfile, err := filepath.Rel(contextDir, filepath.Join(dir, name))
+	if err != nil || !inside(contextDir, filepath.Join(contextDir, dockerfile)) {
+		return "", "", fmt.Errorf("dockerfile %s is outside of build context %s", name, build.GetContext())
+	}
+	return contextDir, filepath.ToSlash(dockerfile), nil
+}
+
+// inside checks if path is dir or inside of it.
+func inside(dir, path string) bool {
+	dir, path = filepath.Clean(dir), filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// dockerignore returns patterns from .dockerignore file in build context
+// directory. Exception patterns starting with ! are not supported and
+// are ignored.
+func dockerignore(dir string) ([]string, error) {
+	file, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") || strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		patterns = append(patterns, strings.Trim(filepath.ToSlash(filepath.Clean(pattern)), "/"))
+	}
+	return patterns, scanner.Err()
+}
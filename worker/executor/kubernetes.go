@@ -0,0 +1,468 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/fs"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/worker/config"
+	"github.com/bleenco/abstruse/worker/git"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	// podStartTimeout defines how long executor waits for job pod
+	// to clone the repository and start.
+	podStartTimeout = 10 * time.Minute
+
+	workspacePath = "/build"
+	jobContainer  = "job"
+	secretPath    = "/etc/abstruse"
+)
+
+// cloneScript clones the repository into workspace in init container.
+const cloneScript = `set -e
+if [ -f /etc/abstruse/password ]; then
+  git config --global credential.helper '!f() { echo "username=$(cat /etc/abstruse/username)"; echo "password=$(cat /etc/abstruse/password)"; }; f'
+fi
+export GIT_LFS_SKIP_SMUDGE=1
+git clone ${REPO_DEPTH:+--depth "$REPO_DEPTH"} "$REPO_URL" /build
+cd /build
+git fetch ${REPO_DEPTH:+--depth "$REPO_DEPTH"} origin "$REPO_REF"
+git checkout -qf "$REPO_COMMIT"
+if [ "$REPO_SUBMODULES" = "true" ]; then
+  git submodule update --init --recursive
+fi
+if [ "$REPO_LFS" = "true" ]; then
+  git lfs pull
+fi
+`
+
+// execFunc executes command in container of the pod and returns its
+// exit code.
+type execFunc func(ctx context.Context, pod, container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
+// kubernetesExecutor runs each job as a pod. Workspace is an emptyDir
+// volume populated by init container which clones the repository, and
+// commands are executed in job container.
+type kubernetesExecutor struct {
+	mu      sync.Mutex
+	client  kubernetes.Interface
+	exec    execFunc
+	config  *config.Kubernetes
+	job     *pb.Job
+	env     []string
+	name    string
+	dir     string
+	shell   string
+	created bool
+	stopped bool
+}
+
+// newKubernetesExecutor returns kubernetes executor using clientset
+// client. Commands are executed with exec, which makes it possible to
+// use executor with fake clientset.
+func newKubernetesExecutor(name string, job *pb.Job, env []string, config *config.Kubernetes, client kubernetes.Interface, exec execFunc) *kubernetesExecutor {
+	return &kubernetesExecutor{
+		client: client,
+		exec:   exec,
+		config: config,
+		job:    job,
+		env:    env,
+		name:   fmt.Sprintf("%s-%s", name, lib.RandomString()),
+	}
+}
+
+// newKubernetesClient returns clientset and exec function from kubeconfig
+// file or in-cluster configuration when kubeconfig is not set.
+func newKubernetesClient(config *config.Kubernetes) (kubernetes.Interface, execFunc, error) {
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exec := func(ctx context.Context, pod, container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+		req := client.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(config.Namespace).
+			Name(pod).
+			SubResource("exec").
+			VersionedParams(&corev1.PodExecOptions{
+				Container: container,
+				Command:   cmd,
+				Stdin:     stdin != nil,
+				Stdout:    true,
+				Stderr:    true,
+			}, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+		if err != nil {
+			return 0, err
+		}
+		err = executor.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+		if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.Exited() {
+			return exitErr.ExitStatus(), nil
+		}
+		return 0, err
+	}
+
+	return client, exec, nil
+}
+
+// ClonesRepository returns true, repository is cloned by init container.
+func (e *kubernetesExecutor) ClonesRepository() bool {
+	return true
+}
+
+func (e *kubernetesExecutor) Prepare(ctx context.Context, dir string, logch chan<- []byte) error {
+	if e.job.GetImage() == "" {
+		return fmt.Errorf("image not specified")
+	}
+
+	pod, secret, err := e.podSpec()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return ErrStopped
+	}
+	e.created = true
+	e.dir = dir
+	e.mu.Unlock()
+
+	pods := e.client.CoreV1().Pods(e.config.Namespace)
+	if secret != nil {
+		if _, err := e.client.CoreV1().Secrets(e.config.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+	logch <- []byte(yellow(fmt.Sprintf("==> Creating pod %s in namespace %s...\r\n", e.name, e.config.Namespace)))
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	if err := e.waitRunning(ctx, logch); err != nil {
+		return err
+	}
+
+	e.shell = "sh"
+	if code, err := e.exec(ctx, e.name, jobContainer, []string{"sh", "-c", "command -v bash"}, nil, ioutil.Discard, ioutil.Discard); err == nil && code == 0 {
+		e.shell = "bash"
+	}
+
+	return nil
+}
+
+func (e *kubernetesExecutor) Run(ctx context.Context, command string, logch chan<- []byte) (int, error) {
+	e.mu.Lock()
+	stopped := e.stopped
+	e.mu.Unlock()
+	if stopped {
+		return 0, ErrStopped
+	}
+
+	w := &logWriter{logch}
+	code, err := e.exec(ctx, e.name, jobContainer, []string{e.shell, "-c", command}, nil, w, w)
+
+	e.mu.Lock()
+	stopped = e.stopped
+	e.mu.Unlock()
+	if stopped {
+		return 0, ErrStopped
+	}
+	return code, err
+}
+
+// WriteFile writes file into the pod workspace.
+func (e *kubernetesExecutor) WriteFile(ctx context.Context, path string, data []byte) error {
+	var out bytes.Buffer
+	cmd := []string{"sh", "-c", `mkdir -p "$(dirname "$1")" && cat > "$1"`, "sh", workspacePath + "/" + path}
+	code, err := e.exec(ctx, e.name, jobContainer, cmd, bytes.NewReader(data), &out, &out)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("error writing %s to pod: %s", path, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// Collect copies paths from the pod workspace into workspace
+// directory on the worker.
+func (e *kubernetesExecutor) Collect(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var buf, stderr bytes.Buffer
+	cmd := append([]string{"tar", "cf", "-", "-C", workspacePath}, paths...)
+	code, err := e.exec(ctx, e.name, jobContainer, cmd, nil, &buf, &stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("error collecting files from pod: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return extractTar(&buf, e.dir)
+}
+
+// Restore copies paths from workspace directory on the worker into the
+// pod workspace.
+func (e *kubernetesExecutor) Restore(ctx context.Context, paths []string) error {
+	var include []string
+	for _, path := range paths {
+		if fs.Exists(filepath.Join(e.dir, path)) {
+			include = append(include, path)
+		}
+	}
+	if len(include) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := createTarPaths(&buf, e.dir, include); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	code, err := e.exec(ctx, e.name, jobContainer, []string{"tar", "xf", "-", "-C", workspacePath}, &buf, &out, &out)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("error copying workspace files to pod: %s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func (e *kubernetesExecutor) Cleanup(ctx context.Context) error {
+	e.mu.Lock()
+	created := e.created
+	e.stopped = true
+	e.mu.Unlock()
+
+	if !created {
+		return nil
+	}
+
+	grace := int64(0)
+	opts := metav1.DeleteOptions{GracePeriodSeconds: &grace}
+	err := e.client.CoreV1().Pods(e.config.Namespace).Delete(ctx, e.name, opts)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = e.client.CoreV1().Secrets(e.config.Namespace).Delete(ctx, e.name, opts)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// waitRunning waits for the pod to start. Output of the clone init
+// container is sent to logch.
+func (e *kubernetesExecutor) waitRunning(ctx context.Context, logch chan<- []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, podStartTimeout)
+	defer cancel()
+
+	pods := e.client.CoreV1().Pods(e.config.Namespace)
+	for {
+		pod, err := pods.Get(ctx, e.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, status := range pod.Status.InitContainerStatuses {
+			if t := status.State.Terminated; t != nil && t.ExitCode != 0 {
+				e.cloneLogs(ctx, logch)
+				return fmt.Errorf("cloning repository failed with exit code %d", t.ExitCode)
+			}
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			e.cloneLogs(ctx, logch)
+			return nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return fmt.Errorf("pod %s %s: %s", e.name, strings.ToLower(string(pod.Status.Phase)), pod.Status.Message)
+		}
+
+		e.mu.Lock()
+		stopped := e.stopped
+		e.mu.Unlock()
+		if stopped {
+			return ErrStopped
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod %s did not start in %s", e.name, podStartTimeout)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// cloneLogs sends logs of the clone init container to logch.
+func (e *kubernetesExecutor) cloneLogs(ctx context.Context, logch chan<- []byte) {
+	data, err := e.client.CoreV1().Pods(e.config.Namespace).GetLogs(e.name, &corev1.PodLogOptions{Container: "clone"}).DoRaw(ctx)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	(&logWriter{logch}).Write(data)
+}
+
+// podSpec returns job pod and secret with repository credentials,
+// secret is nil when repository is cloned without credentials.
+func (e *kubernetesExecutor) podSpec() (*corev1.Pod, *corev1.Secret, error) {
+	resources, err := e.resources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "abstruse",
+		"abstruse.job":                 fmt.Sprintf("%d", e.job.GetId()),
+	}
+
+	opts := git.JobOptions(e.job)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: e.name, Labels: labels},
+		Data:       make(map[string][]byte),
+	}
+	if opts.Password != "" {
+		secret.Data["username"] = []byte(opts.Username)
+		secret.Data["password"] = []byte(opts.Password)
+	}
+
+	var env []corev1.EnvVar
+	for _, e := range e.env {
+		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+			env = append(env, corev1.EnvVar{Name: kv[0], Value: kv[1]})
+		}
+	}
+
+	workspace := corev1.VolumeMount{Name: "workspace", MountPath: workspacePath}
+	clone := corev1.Container{
+		Name:    "clone",
+		Image:   e.config.CloneImage,
+		Command: []string{"/bin/sh", "-c", cloneScript},
+		Env: []corev1.EnvVar{
+			{Name: "REPO_URL", Value: opts.URL},
+			{Name: "REPO_REF", Value: e.job.GetRef()},
+			{Name: "REPO_COMMIT", Value: e.job.GetCommitSHA()},
+			{Name: "REPO_SUBMODULES", Value: strconv.FormatBool(opts.Submodules)},
+			{Name: "REPO_LFS", Value: strconv.FormatBool(opts.LFS)},
+		},
+		VolumeMounts: []corev1.VolumeMount{workspace},
+	}
+	if opts.Depth > 0 {
+		clone.Env = append(clone.Env, corev1.EnvVar{Name: "REPO_DEPTH", Value: strconv.Itoa(opts.Depth)})
+	}
+	volumes := []corev1.Volume{{
+		Name:         "workspace",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}}
+	if len(secret.Data) > 0 {
+		mode := int32(0400)
+		volumes = append(volumes, corev1.Volume{
+			Name: "credentials",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+				SecretName:  e.name,
+				DefaultMode: &mode,
+			}},
+		})
+		clone.VolumeMounts = append(clone.VolumeMounts, corev1.VolumeMount{
+			Name:      "credentials",
+			MountPath: secretPath,
+			ReadOnly:  true,
+		})
+	} else {
+		secret = nil
+	}
+
+	automount := e.config.ServiceAccount != ""
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: e.name, Labels: labels},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ServiceAccountName:           e.config.ServiceAccount,
+			AutomountServiceAccountToken: &automount,
+			InitContainers:               []corev1.Container{clone},
+			Containers: []corev1.Container{{
+				Name:         jobContainer,
+				Image:        e.job.GetImage(),
+				Command:      []string{"/bin/sh", "-c", "while true; do sleep 3600; done"},
+				WorkingDir:   workspacePath,
+				Env:          env,
+				Resources:    resources,
+				VolumeMounts: []corev1.VolumeMount{workspace},
+			}},
+			Volumes: volumes,
+		},
+	}
+
+	return pod, secret, nil
+}
+
+// resources returns resource requirements of job container.
+func (e *kubernetesExecutor) resources() (corev1.ResourceRequirements, error) {
+	req := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, r := range []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{req.Requests, corev1.ResourceCPU, e.config.CPURequest},
+		{req.Limits, corev1.ResourceCPU, e.config.CPULimit},
+		{req.Requests, corev1.ResourceMemory, e.config.MemoryRequest},
+		{req.Limits, corev1.ResourceMemory, e.config.MemoryLimit},
+	} {
+		if r.value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return req, fmt.Errorf("invalid %s quantity %q: %v", r.name, r.value, err)
+		}
+		r.list[r.name] = q
+	}
+	return req, nil
+}
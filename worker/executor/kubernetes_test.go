@@ -0,0 +1,266 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/worker/config"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "abstruse-test"
+
+// execCall is command executed in the pod with stub exec function.
+type execCall struct {
+	pod, container string
+	cmd            []string
+}
+
+// stubExec records executed commands and replies with fixed exit code
+// and output.
+type stubExec struct {
+	mu     sync.Mutex
+	calls  []execCall
+	code   int
+	output string
+}
+
+func (s *stubExec) exec(ctx context.Context, pod, container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	s.mu.Lock()
+	s.calls = append(s.calls, execCall{pod, container, cmd})
+	code, output := s.code, s.output
+	s.mu.Unlock()
+	if stdin != nil {
+		io.Copy(ioutil.Discard, stdin)
+	}
+	fmt.Fprint(stdout, output)
+	return code, nil
+}
+
+// newTestKubernetesExecutor returns executor with fake clientset which
+// starts created pods immediately.
+func newTestKubernetesExecutor(job *pb.Job, cfg *config.Kubernetes) (*kubernetesExecutor, *fake.Clientset, *stubExec) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Status.Phase = corev1.PodRunning
+		return false, nil, nil
+	})
+	stub := &stubExec{}
+	cfg.Namespace = testNamespace
+	return newKubernetesExecutor("abstruse-job-7", job, []string{"FOO=bar"}, cfg, client, stub.exec), client, stub
+}
+
+func drain(logch chan []byte) string {
+	close(logch)
+	var out strings.Builder
+	for data := range logch {
+		out.Write(data)
+	}
+	return out.String()
+}
+
+func TestKubernetesPrepareCreatesPod(t *testing.T) {
+	job := &pb.Job{
+		Id:          7,
+		Image:       "golang:1.16",
+		Url:         "https://github.com/bleenco/abstruse.git",
+		Ref:         "refs/heads/master",
+		CommitSHA:   "0123456789abcdef0123456789abcdef01234567",
+		Credentials: &pb.Credentials{Url: "https://abstruse/git/bleenco/abstruse.git", Username: "abstruse", Password: "secret"},
+		Git:         &pb.Git{Depth: 1, Submodules: true},
+	}
+	e, client, _ := newTestKubernetesExecutor(job, &config.Kubernetes{
+		CloneImage:    "alpine/git",
+		CPURequest:    "500m",
+		CPULimit:      "2",
+		MemoryRequest: "256Mi",
+		MemoryLimit:   "1Gi",
+	})
+
+	logch := make(chan []byte, 1024)
+	if err := e.Prepare(context.Background(), t.TempDir(), logch); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	drain(logch)
+
+	pod, err := client.CoreV1().Pods(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod not created: %v", err)
+	}
+	if got := pod.Labels["app.kubernetes.io/managed-by"]; got != "abstruse" {
+		t.Errorf("managed-by label = %q, want abstruse", got)
+	}
+	if got := pod.Labels["abstruse.job"]; got != "7" {
+		t.Errorf("job label = %q, want 7", got)
+	}
+
+	if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Name != jobContainer {
+		t.Fatalf("containers = %+v, want single %s container", pod.Spec.Containers, jobContainer)
+	}
+	c := pod.Spec.Containers[0]
+	if c.Image != "golang:1.16" {
+		t.Errorf("image = %q, want golang:1.16", c.Image)
+	}
+	for _, r := range []struct {
+		list corev1.ResourceList
+		name corev1.ResourceName
+		want string
+	}{
+		{c.Resources.Requests, corev1.ResourceCPU, "500m"},
+		{c.Resources.Limits, corev1.ResourceCPU, "2"},
+		{c.Resources.Requests, corev1.ResourceMemory, "256Mi"},
+		{c.Resources.Limits, corev1.ResourceMemory, "1Gi"},
+	} {
+		q, ok := r.list[r.name]
+		if want := resource.MustParse(r.want); !ok || q.Cmp(want) != 0 {
+			t.Errorf("%s = %s, want %s", r.name, q.String(), r.want)
+		}
+	}
+	if len(c.Env) != 1 || c.Env[0].Name != "FOO" || c.Env[0].Value != "bar" {
+		t.Errorf("env = %+v, want FOO=bar", c.Env)
+	}
+
+	if len(pod.Spec.InitContainers) != 1 {
+		t.Fatalf("init containers = %d, want 1", len(pod.Spec.InitContainers))
+	}
+	env := make(map[string]string)
+	for _, e := range pod.Spec.InitContainers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["REPO_URL"] != job.Credentials.Url || env["REPO_DEPTH"] != "1" || env["REPO_COMMIT"] != job.CommitSHA {
+		t.Errorf("clone env = %v", env)
+	}
+
+	secret, err := client.CoreV1().Secrets(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("secret not created: %v", err)
+	}
+	if string(secret.Data["password"]) != "secret" || string(secret.Data["username"]) != "abstruse" {
+		t.Errorf("secret data = %v", secret.Data)
+	}
+}
+
+func TestKubernetesPrepareSSHRepository(t *testing.T) {
+	job := &pb.Job{
+		Id:          7,
+		Image:       "alpine",
+		SshClone:    true,
+		SshURL:      "git@github.com:bleenco/abstruse.git",
+		Credentials: &pb.Credentials{Url: "https://abstruse/git/bleenco/abstruse.git", Username: "abstruse", Password: "secret"},
+	}
+	e, client, _ := newTestKubernetesExecutor(job, &config.Kubernetes{})
+
+	logch := make(chan []byte, 1024)
+	if err := e.Prepare(context.Background(), t.TempDir(), logch); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	drain(logch)
+
+	pod, err := client.CoreV1().Pods(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod not created: %v", err)
+	}
+	for _, env := range pod.Spec.InitContainers[0].Env {
+		if env.Name == "REPO_URL" && env.Value != job.Credentials.Url {
+			t.Errorf("REPO_URL = %s, want git proxy URL %s", env.Value, job.Credentials.Url)
+		}
+	}
+	secret, err := client.CoreV1().Secrets(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("secret not created: %v", err)
+	}
+	if len(secret.Data) != 2 || string(secret.Data["password"]) != "secret" {
+		t.Errorf("secret data = %v, want only clone credentials", secret.Data)
+	}
+}
+
+func TestKubernetesRunExitCode(t *testing.T) {
+	e, _, stub := newTestKubernetesExecutor(&pb.Job{Id: 7, Image: "alpine"}, &config.Kubernetes{})
+
+	logch := make(chan []byte, 1024)
+	if err := e.Prepare(context.Background(), t.TempDir(), logch); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+
+	stub.mu.Lock()
+	stub.code, stub.output = 3, "command output"
+	stub.mu.Unlock()
+
+	code, err := e.Run(context.Background(), "exit 3", logch)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if code != 3 {
+		t.Errorf("Run() code = %d, want 3", code)
+	}
+	if out := drain(logch); !strings.Contains(out, "command output") {
+		t.Errorf("log = %q, want command output", out)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	last := stub.calls[len(stub.calls)-1]
+	if last.pod != e.name || last.container != jobContainer {
+		t.Errorf("exec in %s/%s, want %s/%s", last.pod, last.container, e.name, jobContainer)
+	}
+	if cmd := last.cmd; len(cmd) != 3 || cmd[1] != "-c" || cmd[2] != "exit 3" {
+		t.Errorf("exec command = %v", cmd)
+	}
+}
+
+func TestKubernetesCleanup(t *testing.T) {
+	job := &pb.Job{Id: 7, Image: "alpine", Credentials: &pb.Credentials{Url: "https://abstruse/git/a/b.git", Username: "abstruse", Password: "secret"}}
+	e, client, _ := newTestKubernetesExecutor(job, &config.Kubernetes{})
+
+	logch := make(chan []byte, 1024)
+	if err := e.Prepare(context.Background(), t.TempDir(), logch); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	drain(logch)
+
+	if err := e.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if _, err := client.CoreV1().Pods(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pod not deleted: %v", err)
+	}
+	if _, err := client.CoreV1().Secrets(testNamespace).Get(context.Background(), e.name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("secret not deleted: %v", err)
+	}
+
+	if _, err := e.Run(context.Background(), "true", make(chan []byte, 1)); err != ErrStopped {
+		t.Errorf("Run() after Cleanup() error = %v, want ErrStopped", err)
+	}
+	if err := e.Cleanup(context.Background()); err != nil {
+		t.Errorf("second Cleanup() error = %v", err)
+	}
+}
+
+func TestKubernetesCleanupBeforePrepare(t *testing.T) {
+	e, client, _ := newTestKubernetesExecutor(&pb.Job{Id: 7, Image: "alpine"}, &config.Kubernetes{})
+
+	if err := e.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("unexpected %s of %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	if err := e.Prepare(context.Background(), t.TempDir(), make(chan []byte, 16)); err != ErrStopped {
+		t.Errorf("Prepare() after Cleanup() error = %v, want ErrStopped", err)
+	}
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/worker/config"
+	"github.com/bleenco/abstruse/worker/docker"
+	"github.com/dustin/go-humanize"
+)
+
+// ErrOOMKilled is returned when command was killed for running out of
+// memory.
+var ErrOOMKilled = errors.New("OOM killed")
+
+// resources returns resource limits of the job container. Worker
+// defaults apply to limits job does not specify and all limits are
+// clamped to the maximums configured on the worker.
+func resources(job *pb.Job, config *config.Resources) (docker.Resources, error) {
+	r := docker.Resources{
+		CPUs:   job.GetResources().GetCpus(),
+		Memory: job.GetResources().GetMemory(),
+		Pids:   job.GetResources().GetPids(),
+	}
+	if config == nil {
+		return r, nil
+	}
+
+	memory, err := lib.ParseMemory(config.Memory)
+	if err != nil {
+		return r, err
+	}
+	maxMemory, err := lib.ParseMemory(config.MaxMemory)
+	if err != nil {
+		return r, err
+	}
+
+	if r.CPUs == 0 {
+		r.CPUs = config.CPUs
+	}
+	if r.Memory == 0 {
+		r.Memory = memory
+	}
+	if r.Pids == 0 {
+		r.Pids = config.Pids
+	}
+	if config.MaxCPUs > 0 && (r.CPUs == 0 || r.CPUs > config.MaxCPUs) {
+		r.CPUs = config.MaxCPUs
+	}
+	if maxMemory > 0 && (r.Memory == 0 || r.Memory > maxMemory) {
+		r.Memory = maxMemory
+	}
+	if config.MaxPids > 0 && (r.Pids == 0 || r.Pids > config.MaxPids) {
+		r.Pids = config.MaxPids
+	}
+	return r, nil
+}
+
+// formatResources returns resource limits as displayed in the job log.
+func formatResources(r docker.Resources) string {
+	var limits []string
+	if r.CPUs > 0 {
+		limits = append(limits, fmt.Sprintf("cpus %g", r.CPUs))
+	}
+	if r.Memory > 0 {
+		limits = append(limits, fmt.Sprintf("memory %s", humanize.IBytes(uint64(r.Memory))))
+	}
+	if r.Pids > 0 {
+		limits = append(limits, fmt.Sprintf("pids %d", r.Pids))
+	}
+	return strings.Join(limits, ", ")
+}
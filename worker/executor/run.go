@@ -0,0 +1,169 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/bleenco/abstruse/pb"
+	"github.com/bleenco/abstruse/pkg/lib"
+	"github.com/bleenco/abstruse/worker/config"
+)
+
+// Run runs job steps with executor e in workspace directory dir. Each
+// step runs as one shell script and steps run sequentially in the shared
+// workspace. Step runs when the job status matches its when condition,
+// failing step marks the job as failed unless it continues on error.
+// after_success and after_failure steps of version 1 config run only
+// when script phase has been reached. Cache keys are computed and caches
+// restored once the environment is prepared, caches are saved after the
+// last install or script step. It returns *PhaseError when a step fails,
+// step killed for running out of memory fails and errors the job.
+func Run(ctx context.Context, e Executor, job *pb.Job, config *config.Config, dir string, logch chan<- []byte) error {
+	if err := e.Prepare(ctx, dir, logch); err != nil {
+		logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
+		return err
+	}
+
+	caches := cacheKeys(ctx, e, job, dir, logch)
+	restoreCache(ctx, e, job, caches, config, dir, logch)
+
+	logch <- []byte(yellow("==> Starting build...\r\n"))
+
+	steps := job.GetSteps()
+	cacheIndex := -1
+	for i, step := range steps {
+		if step.GetType() == pb.Command_Install || step.GetType() == pb.Command_Script {
+			cacheIndex = i
+		}
+	}
+
+	var failed *PhaseError
+	for i, step := range steps {
+		if !shouldRun(step, failed) {
+			continue
+		}
+		if len(steps) > 1 {
+			logch <- []byte(yellow(fmt.Sprintf("\r==> Step %s\r\n", step.GetName())))
+		}
+
+		code, err := runStep(ctx, e, i+1, step, job.GetImage(), dir, logch)
+		oomKilled := err == ErrOOMKilled
+		if err != nil && !oomKilled {
+			logch <- []byte(fmt.Sprintf("%s\r\n", err.Error()))
+			return err
+		}
+		if oomKilled {
+			logch <- []byte(yellow(fmt.Sprintf("\r==> Step %s was killed for running out of memory\r\n", step.GetName())))
+		}
+		if code != 0 {
+			if step.GetContinueOnError() {
+				logch <- []byte(yellow(fmt.Sprintf("\r==> Step %s failed with exit code %d, continuing\r\n", step.GetName(), code)))
+			} else if failed == nil {
+				failed = &PhaseError{Phase: step.GetName(), Type: step.GetType(), Code: code, OOMKilled: oomKilled}
+			}
+			continue
+		}
+
+		// save cache.
+		if i == cacheIndex && failed == nil {
+			saveCache(ctx, e, job, caches, config, dir, logch)
+		}
+	}
+
+	exitCode := 0
+	if failed != nil {
+		exitCode = failed.Code
+	}
+	logch <- []byte(genExitMessage(exitCode))
+
+	if failed != nil {
+		return failed
+	}
+	return nil
+}
+
+// shouldRun checks if step runs with regard to the failed step.
+func shouldRun(step *pb.Step, failed *PhaseError) bool {
+	status := "success"
+	if failed != nil {
+		status = "failure"
+	}
+	if !lib.Include(step.GetWhen(), status) {
+		return false
+	}
+	// script phase has not been reached.
+	if failed != nil && failed.preparing() {
+		return step.GetType() != pb.Command_AfterSuccess && step.GetType() != pb.Command_AfterFailure
+	}
+	return true
+}
+
+// runStep writes script of the step into the workspace and runs it.
+// Step with image different than the image of the job runs in its own
+// environment sharing the workspace, plugin step runs its image and
+// docker step builds an image from the workspace.
+func runStep(ctx context.Context, e Executor, i int, step *pb.Step, image, dir string, logch chan<- []byte) (int, error) {
+	if step.GetDocker() != nil {
+		return runDockerBuild(ctx, e, step.GetDocker(), dir, logch)
+	}
+
+	if step.GetUses() != "" {
+		runner, ok := e.(PluginRunner)
+		if !ok {
+			return 0, fmt.Errorf("step %s: executor does not support plugins", step.GetName())
+		}
+		return runner.RunPlugin(ctx, fmt.Sprintf("step%d", i), step.GetUses(), step.GetEnv(), logch)
+	}
+
+	path := scriptPath(i, step)
+	if err := e.WriteFile(ctx, path, []byte(script(step))); err != nil {
+		return 0, err
+	}
+
+	if step.GetImage() != "" && step.GetImage() != image {
+		imager, ok := e.(Imager)
+		if !ok {
+			return 0, fmt.Errorf("step %s: executor does not support step images", step.GetName())
+		}
+		se := imager.WithImage(fmt.Sprintf("step%d", i), step.GetImage())
+		defer se.Cleanup(context.Background())
+		if err := se.Prepare(ctx, dir, logch); err != nil {
+			return 0, err
+		}
+		e = se
+	}
+
+	return e.Run(ctx, scriptCommand(step, path), logch)
+}
+
+// PhaseError is returned by Run when a step of the job fails.
+type PhaseError struct {
+	Phase     string
+	Type      pb.Command_CommandType
+	Code      int
+	OOMKilled bool
+}
+
+func (e *PhaseError) Error() string {
+	if e.OOMKilled {
+		return fmt.Sprintf("%s failed with exit code %d (%s)", e.Phase, e.Code, ErrOOMKilled)
+	}
+	return fmt.Sprintf("%s failed with exit code %d", e.Phase, e.Code)
+}
+
+// Errored returns true when failed step prepares the build or it was
+// killed for running out of memory, job is then errored rather than
+// failing.
+func (e *PhaseError) Errored() bool {
+	return e.OOMKilled || e.preparing()
+}
+
+// preparing returns true when failed step prepares the build.
+func (e *PhaseError) preparing() bool {
+	switch e.Type {
+	case pb.Command_BeforeInstall, pb.Command_Install, pb.Command_BeforeScript, pb.Command_BeforeDeploy:
+		return true
+	default:
+		return false
+	}
+}
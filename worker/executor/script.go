@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "github.com/bleenco/abstruse/pb"
+)
+
+// scriptDir is a directory in the workspace where step scripts and
+// shell state shared between them are stored.
+const scriptDir = ".abstruse"
+
+var unsafeChars = regexp.MustCompile("[^a-z0-9]+")
+
+// scriptPath returns path of the i-th step script relative to the
+// workspace.
+func scriptPath(i int, step *pb.Step) string {
+	name := strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(step.GetName()), "_"), "_")
+	return fmt.Sprintf("%s/%d-%s.sh", scriptDir, i, name)
+}
+
+// scriptCommand returns command which runs step script at path, script
+// is sourced by the shell of the executor unless step specifies shell.
+func scriptCommand(step *pb.Step, path string) string {
+	if step.GetShell() != "" {
+		return step.GetShell() + " " + path
+	}
+	return ". " + path
+}
+
+// envNames prints names of exported environment variables.
+const envNames = `__abstruse_names() { env | sed -n 's/^\([A-Za-z_][A-Za-z0-9_]*\)=.*/\1/p'; }
+`
+
+// envSnapshot saves values of exported environment variables at start
+// of the step into shell variables, they are not exported.
+const envSnapshot = `for __abstruse_n in $(__abstruse_names); do eval "__abstruse_s_$__abstruse_n=\${$__abstruse_n}"; done
+`
+
+// envSave appends variables exported or changed by the step to the env
+// file as POSIX export lines. Variables of the image and shell are not
+// saved, so steps with different images keep their own PATH and HOME.
+const envSave = `__abstruse_save() {
+  set +e
+  for __abstruse_n in $(__abstruse_names); do
+    case "$__abstruse_n" in
+      PATH|HOME|HOSTNAME|PWD|OLDPWD|SHLVL|_|__abstruse_*%s) continue ;;
+    esac
+    eval "__abstruse_v=\${$__abstruse_n}; __abstruse_set=\${__abstruse_s_$__abstruse_n+x}; __abstruse_old=\${__abstruse_s_$__abstruse_n}"
+    if [ -z "$__abstruse_set" ] || [ "$__abstruse_v" != "$__abstruse_old" ]; then
+      printf "export %%s='%%s'\n" "$__abstruse_n" "$(printf '%%s' "$__abstruse_v" | sed "s/'/'\\\\''/g")" >> "$__abstruse_dir/env"
+    fi
+  done
+}
+`
+
+// script returns shell script which runs commands of the step with
+// errexit semantics and prints each command before it is executed.
+// Environment variables exported by the step and working directory are
+// saved when script exits and restored by the script of next step, so
+// they carry over between steps. Environment variables of the step and
+// its working directory only apply to the step itself.
+func script(step *pb.Step) string {
+	var b strings.Builder
+
+	b.WriteString("# generated by abstruse, do not edit\n")
+	b.WriteString("__abstruse_dir=\"$(pwd)/" + scriptDir + "\"\n")
+	b.WriteString("if [ -f \"$__abstruse_dir/env\" ]; then . \"$__abstruse_dir/env\"; fi\n")
+	b.WriteString(envNames)
+	b.WriteString(envSnapshot)
+
+	var names string
+	for _, env := range step.GetEnv() {
+		if i := strings.Index(env, "="); i > 0 {
+			names += "|" + quote(env[:i])
+			b.WriteString("export " + env[:i] + "=" + quote(env[i+1:]) + "\n")
+		}
+	}
+	b.WriteString(fmt.Sprintf(envSave, names))
+	save := "__abstruse_save"
+
+	if dir := step.GetWorkingDir(); dir != "" {
+		b.WriteString("mkdir -p " + quote(dir) + " && cd " + quote(dir) + "\n")
+	} else {
+		b.WriteString("if [ -f \"$__abstruse_dir/cwd\" ]; then cd \"$(cat \"$__abstruse_dir/cwd\")\"; fi\n")
+		save = save + "; pwd > \"$__abstruse_dir/cwd\""
+	}
+	b.WriteString("trap '" + strings.ReplaceAll(save, "'", `'\''`) + "' EXIT\n")
+	b.WriteString("set -e\n")
+
+	for _, command := range step.GetCommands() {
+		marker := yellow("\r==> " + strings.ReplaceAll(command, "\n", "\r\n") + "\r\n")
+		b.WriteString("\nprintf '%s' " + quote(marker) + "\n")
+		b.WriteString(command + "\n")
+	}
+
+	return b.String()
+}
+
+// quote returns s quoted for shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,128 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// shellExecutor runs commands directly on the worker host in the
+// workspace directory.
+type shellExecutor struct {
+	mu      sync.Mutex
+	env     []string
+	dir     string
+	shell   string
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+func newShellExecutor(env []string) *shellExecutor {
+	return &shellExecutor{env: env}
+}
+
+func (e *shellExecutor) Prepare(ctx context.Context, dir string, logch chan<- []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return ErrStopped
+	}
+
+	e.dir = dir
+	e.shell = "sh"
+	if path, err := exec.LookPath("bash"); err == nil {
+		e.shell = path
+	}
+	logch <- []byte(yellow(fmt.Sprintf("==> Using host shell %s in %s\r\n", e.shell, dir)))
+	return nil
+}
+
+func (e *shellExecutor) Run(ctx context.Context, command string, logch chan<- []byte) (int, error) {
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return 0, ErrStopped
+	}
+	cmd := exec.CommandContext(ctx, e.shell, "-c", command)
+	cmd.Dir = e.dir
+	cmd.Env = append(hostEnv(), e.env...)
+	cmd.Stdout = &logWriter{logch}
+	cmd.Stderr = cmd.Stdout
+	// run command in its own process group so all processes it
+	// started can be killed on cleanup.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		e.mu.Unlock()
+		return 0, err
+	}
+	e.cmd = cmd
+	e.mu.Unlock()
+
+	err := cmd.Wait()
+
+	e.mu.Lock()
+	e.cmd = nil
+	stopped := e.stopped
+	e.mu.Unlock()
+
+	if stopped {
+		return 0, ErrStopped
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+func (e *shellExecutor) WriteFile(ctx context.Context, path string, data []byte) error {
+	return writeFile(e.dir, path, data)
+}
+
+// Collect is a no-op, workspace is a directory on the worker.
+func (e *shellExecutor) Collect(ctx context.Context, paths []string) error {
+	return nil
+}
+
+// Restore is a no-op, workspace is a directory on the worker.
+func (e *shellExecutor) Restore(ctx context.Context, paths []string) error {
+	return nil
+}
+
+func (e *shellExecutor) Cleanup(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stopped = true
+	if e.cmd == nil || e.cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-e.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// hostEnv returns environment of the worker process without abstruse
+// configuration variables, which may hold worker credentials.
+func hostEnv() []string {
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "ABSTRUSE_") {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+// logWriter writes command output to log channel, line endings are
+// converted as the output is displayed in terminal emulator.
+type logWriter struct {
+	logch chan<- []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.logch <- bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))
+	return len(p), nil
+}
@@ -2,52 +2,75 @@ package git
 
 import (
 	"fmt"
-	"net"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
 
+	pb "github.com/bleenco/abstruse/pb"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
-	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
-	"golang.org/x/crypto/ssh"
 )
 
+// CloneOptions defines repository, its revision and how it is cloned.
+// Repository is cloned over HTTP with username and password when
+// provided. Depth 0 clones full history.
+type CloneOptions struct {
+	URL        string
+	Username   string
+	Password   string
+	Ref        string
+	Commit     string
+	Depth      int
+	Submodules bool
+	LFS        bool
+}
+
+// defaultDepth is clone depth of jobs without git options.
+const defaultDepth = 50
+
+// JobOptions returns clone options of the job, repository is cloned
+// through git proxy of the server when job has credentials, also when it
+// is set to clone over SSH.
+func JobOptions(job *pb.Job) CloneOptions {
+	opts := CloneOptions{
+		URL:        job.GetUrl(),
+		Ref:        job.GetRef(),
+		Commit:     job.GetCommitSHA(),
+		Depth:      defaultDepth,
+		Submodules: true,
+	}
+	if c := job.GetCredentials(); c != nil {
+		opts.URL, opts.Username, opts.Password = c.GetUrl(), c.GetUsername(), c.GetPassword()
+	}
+	if g := job.GetGit(); g != nil {
+		opts.Depth, opts.Submodules, opts.LFS = int(g.GetDepth()), g.GetSubmodules(), g.GetLfs()
+	}
+	return opts
+}
+
+// endpoint returns URL repository is cloned from and its authentication.
+func (o CloneOptions) endpoint() (string, transport.AuthMethod, error) {
+	if o.Password == "" {
+		return o.URL, nil, nil
+	}
+	return o.URL, &http.BasicAuth{Username: o.Username, Password: o.Password}, nil
+}
+
 // CloneRepository clones repository contents to specified path.
-func CloneRepository(url, ref, commit, token, dir, sshURL string, sshKey []byte, useSSH bool) error {
-	var auth transport.AuthMethod
-	var err error
-
-	if token != "" && !useSSH {
-		auth = &http.BasicAuth{
-			Username: "user",
-			Password: token,
-		}
-	} else if useSSH {
-		url = sshURL
-
-		if sshKey != nil {
-			signer, err := ssh.ParsePrivateKey(sshKey)
-			if err != nil {
-				return err
-			}
-			auth = &gitssh.PublicKeys{
-				User:   "git",
-				Signer: signer,
-				HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
-					HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-						return nil
-					}),
-				},
-			}
-		}
+func CloneRepository(dir string, opts CloneOptions) error {
+	url, auth, err := opts.endpoint()
+	if err != nil {
+		return err
 	}
 
 	r, err := git.PlainClone(dir, false, &git.CloneOptions{
-		URL:               url,
-		Auth:              auth,
-		Depth:             50,
-		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		URL:   url,
+		Auth:  auth,
+		Depth: opts.Depth,
 	})
 	if err != nil {
 		return err
@@ -58,34 +81,86 @@ func CloneRepository(url, ref, commit, token, dir, sshURL string, sshKey []byte,
 		return err
 	}
 
-	if reference.Name().String() != ref {
+	if reference.Name().String() != opts.Ref {
 		if err := r.Fetch(&git.FetchOptions{
 			RefSpecs: []config.RefSpec{
-				config.RefSpec(fmt.Sprintf("%s:%s", ref, ref)),
+				config.RefSpec(fmt.Sprintf("%s:%s", opts.Ref, opts.Ref)),
 			},
-			Auth: auth,
+			Depth: opts.Depth,
+			Auth:  auth,
 		}); err != nil {
 			return err
 		}
 	}
 
+	return checkout(r, dir, opts)
+}
+
+// checkout checks out commit of the repository cloned to dir and
+// updates its submodules and LFS files.
+func checkout(r *git.Repository, dir string, opts CloneOptions) error {
 	w, err := r.Worktree()
 	if err != nil {
 		return err
 	}
 
 	if err := w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.ReferenceName(ref),
+		Hash:  plumbing.NewHash(opts.Commit),
+		Force: true,
 	}); err != nil {
 		return err
 	}
 
-	if err := w.Checkout(&git.CheckoutOptions{
-		Hash:  plumbing.NewHash(commit),
-		Force: true,
-	}); err != nil {
-		return err
+	if opts.Submodules {
+		submodules, err := w.Submodules()
+		if err != nil {
+			return err
+		}
+		// credentials of the repository are not sent to hosts of
+		// submodules.
+		if err := submodules.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		}); err != nil {
+			return err
+		}
 	}
 
+	if opts.LFS {
+		return pullLFS(dir, opts)
+	}
+	return nil
+}
+
+// pullLFS downloads LFS files of the checked out commit with git-lfs,
+// credentials are passed to it with credential helper reading them from
+// environment.
+func pullLFS(dir string, opts CloneOptions) error {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "ABSTRUSE_GIT_USERNAME="+opts.Username, "ABSTRUSE_GIT_PASSWORD="+opts.Password)
+	args := []string{"-c", "credential.helper=", "-c", `credential.helper=!f() { echo "username=$ABSTRUSE_GIT_USERNAME"; echo "password=$ABSTRUSE_GIT_PASSWORD"; }; f`}
+
+	cmd := exec.Command("git", append(args, "lfs", "pull")...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git lfs pull: %v: %s", err, strings.TrimSpace(string(out)))
+	}
 	return nil
 }
+
+// ResolveURL returns clone URL resolved against server address addr,
+// URLs of git proxy of the server are relative to it.
+func ResolveURL(addr, url string) (string, error) {
+	base, err := neturl.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path = base.Path + "/"
+	}
+	ref, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,160 @@
+package git
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Mirror keeps bare mirrors of repositories cloned on the worker, only
+// new commits are fetched into them and job workspaces share their
+// objects with git alternates. Mirrors have full history, shallow
+// repositories can't be updated with go-git.
+type Mirror struct {
+	dir   string
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewMirror returns new Mirror instance keeping mirrors in directory dir.
+func NewMirror(dir string) *Mirror {
+	return &Mirror{dir: dir, locks: make(map[string]*sync.Mutex)}
+}
+
+// Clone fetches ref of the repository into its mirror and creates
+// repository in directory dir using objects of the mirror, then checks
+// out the commit. Contents of dir are removed on error, so repository
+// can be cloned directly instead.
+func (m *Mirror) Clone(dir string, opts CloneOptions) error {
+	err := m.clone(dir, opts)
+	if err != nil {
+		removeContents(dir)
+	}
+	return err
+}
+
+func (m *Mirror) clone(dir string, opts CloneOptions) error {
+	url, auth, err := opts.endpoint()
+	if err != nil {
+		return err
+	}
+
+	path := m.path(url)
+	lock := m.lock(path)
+	lock.Lock()
+	ref, err := m.fetch(path, url, auth, opts)
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	objects := filepath.Join(dir, ".git", "objects", "info")
+	if err := os.MkdirAll(objects, 0755); err != nil {
+		return err
+	}
+	alternates, err := filepath.Abs(filepath.Join(path, "objects"))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(objects, "alternates"), []byte(alternates+"\n"), 0644); err != nil {
+		return err
+	}
+	if _, err := r.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}}); err != nil {
+		return err
+	}
+	if err := r.Storer.SetReference(ref); err != nil {
+		return err
+	}
+
+	return checkout(r, dir, opts)
+}
+
+// fetch fetches ref of the repository from url into mirror at path and
+// returns it, mirror is created when it does not exist.
+func (m *Mirror) fetch(path, url string, auth transport.AuthMethod, opts CloneOptions) (*plumbing.Reference, error) {
+	r, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		if r, err = git.PlainInit(path, true); err == nil {
+			_, err = r.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%s:%s", opts.Ref, opts.Ref)),
+		},
+		Auth: auth,
+		Tags: git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+
+	ref, err := r.Reference(plumbing.ReferenceName(opts.Ref), false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.CommitObject(plumbing.NewHash(opts.Commit)); err != nil {
+		return nil, fmt.Errorf("commit %s not found in %s: %v", opts.Commit, opts.Ref, err)
+	}
+	return ref, nil
+}
+
+// path returns path of the mirror of repository with url.
+func (m *Mirror) path(url string) string {
+	return mirrorPath(m.dir, url)
+}
+
+// MirrorObjects returns objects directory of the mirror of repository
+// with url kept in mirror directory dir.
+func MirrorObjects(dir, url string) string {
+	return filepath.Join(mirrorPath(dir, url), "objects")
+}
+
+func mirrorPath(dir, url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".git")
+}
+
+// lock returns lock of the mirror at path, so the mirror is updated
+// by one job at a time.
+func (m *Mirror) lock(path string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[path] = lock
+	}
+	return lock
+}
+
+// removeContents removes contents of directory dir.
+func removeContents(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
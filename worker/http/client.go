@@ -48,7 +48,8 @@ func NewClient(uri, token string) (*Client, error) {
 	client := &Client{
 		BaseURL: base,
 		Client: &http.Client{
-			Transport: &TokenAuth{Token: token},
+			Transport:     &TokenAuth{Token: token},
+			CheckRedirect: checkRedirect,
 		},
 	}
 
@@ -126,6 +127,38 @@ func (c *Client) Req(ctx context.Context, in *Request, headers ...http.Header) (
 	return newResponse(res), nil
 }
 
+// ReqURL sends a request to absolute URL in.Path, such as presigned
+// storage URL, without authorization.
+func ReqURL(ctx context.Context, in *Request) (*Response, error) {
+	req, err := http.NewRequest(in.Method, in.Path, in.Body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if in.Header != nil {
+		req.Header = in.Header
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResponse(res), nil
+}
+
+// checkRedirect returns redirects to other hosts to the caller, so the
+// token is never sent to them.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.URL.Host != via[0].URL.Host {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return nil
+}
+
 func newResponse(r *http.Response) *Response {
 	return &Response{
 		Status: r.StatusCode,